@@ -17,6 +17,7 @@ func init() {
 	rootCmd.AddCommand(envCmd)
 	rootCmd.AddCommand(mergeCmd)
 	rootCmd.AddCommand(viperCmd)
+	rootCmd.AddCommand(webEnvCmd)
 }
 
 // Execute runs the zxconfig command.
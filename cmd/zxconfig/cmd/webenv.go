@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+var webEnvCmd = &cobra.Command{
+	Use:   "web-env [target...]",
+	Short: "Output the environment configured for web targets",
+	RunE:  RunWebEnv,
+}
+
+// RunWebEnv resolves the env and env_file settings of the named web targets,
+// or every web target if none are named, and outputs the resulting
+// environment as an env file.
+func RunWebEnv(cmd *cobra.Command, args []string) error {
+	config.Init(verbosity)
+
+	cfg, err := config.Get()
+	if err != nil {
+		return err
+	}
+
+	names := args
+	if len(names) == 0 {
+		for name := range cfg.Web.Targets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	for i, name := range names {
+		target, ok := cfg.Web.Targets[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("no web target named %q", name)
+		}
+
+		env, err := target.Environ()
+		if err != nil {
+			return fmt.Errorf("web target %q: %w", name, err)
+		}
+
+		if len(names) > 1 {
+			if i > 0 {
+				fmt.Println("")
+			}
+			fmt.Printf("# %s\n", name)
+		}
+
+		for _, kv := range env {
+			kvs := strings.SplitN(kv, "=", 2)
+			fmt.Printf(`%s="%s"`, kvs[0], cleanValue(kvs[1]))
+			fmt.Println("")
+		}
+	}
+
+	return nil
+}
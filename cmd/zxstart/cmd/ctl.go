@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zostay/dev-tools/internal/control"
+	"github.com/zostay/dev-tools/internal/server"
+	"github.com/zostay/dev-tools/pkg/config"
+	"github.com/zostay/dev-tools/pkg/lifecycle"
+)
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl command [target]",
+	Short: "Control a running zxstart server",
+	Long: `Control a running zxstart server.
+
+The commands are:
+
+  status   show the state of each target
+  rebuild  build and restart the target
+  restart  restart the target without building it
+  stop     stop the target until it is started again
+  start    start a stopped target again
+  pause    ignore changes to the files the target watches
+  resume   act on changes to the files the target watches again
+  events   print a line of JSON for each lifecycle event until interrupted
+
+Every target is controlled unless a target is named.`,
+	Args:      cobra.RangeArgs(1, 2),
+	ValidArgs: control.Commands,
+	RunE:      RunCtl,
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+// ControlledServer is implemented by a Server that can be controlled with
+// zxstart ctl.
+type ControlledServer interface {
+	Server
+
+	// Status returns what the server is doing.
+	Status() server.Status
+
+	// Rebuild builds and restarts the server.
+	Rebuild() error
+
+	// Restart restarts the server without building it.
+	Restart() error
+
+	// Stop stops the server until Resume is called.
+	Stop() error
+
+	// Resume starts the server again after Stop.
+	Resume() error
+
+	// PauseWatching ignores changes to watched files until ResumeWatching
+	// is called.
+	PauseWatching() error
+
+	// ResumeWatching acts on changes to watched files again.
+	ResumeWatching() error
+}
+
+// socketPath returns the path of the control socket.
+func socketPath() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, control.SocketName), nil
+}
+
+// setupControl starts listening for zxstart ctl on the control socket. It
+// returns a function that stops listening.
+func setupControl(
+	order []string,
+	targets map[string]config.WebTarget,
+	workers map[string]Server,
+	done *sync.WaitGroup,
+) (func(), error) {
+	path, err := socketPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return control.Listen(path, controlHandler(order, targets, workers), feed, done)
+}
+
+// controlHandler returns the handler carrying out the requests of zxstart ctl
+// on the servers.
+func controlHandler(
+	order []string,
+	targets map[string]config.WebTarget,
+	workers map[string]Server,
+) control.Handler {
+	return func(req *control.Request) *control.Response {
+		names := make([]string, 0, len(order))
+		for _, name := range order {
+			if _, ok := workers[name]; ok {
+				names = append(names, name)
+			}
+		}
+
+		if req.Target != "" {
+			name := strings.ToLower(req.Target)
+			if _, ok := workers[name]; !ok {
+				return &control.Response{Error: fmt.Sprintf("there is no web target named %q", req.Target)}
+			}
+			names = []string{name}
+		}
+
+		var resp control.Response
+		if req.Command != control.Status {
+			for _, name := range names {
+				cs, ok := workers[name].(ControlledServer)
+				if !ok {
+					if req.Target != "" {
+						resp.Error = fmt.Sprintf("web target %q of type %q cannot be controlled", name, targets[name].Type)
+						break
+					}
+					continue
+				}
+
+				if err := controlServer(cs, req.Command); err != nil {
+					resp.Error = fmt.Sprintf("web target %q: %v", name, err)
+					break
+				}
+			}
+		}
+
+		for _, name := range names {
+			resp.Targets = append(resp.Targets, targetStatus(name, targets[name], workers[name]))
+		}
+
+		return &resp
+	}
+}
+
+// controlServer carries out the command on the server.
+func controlServer(cs ControlledServer, command string) error {
+	switch command {
+	case control.Rebuild:
+		return cs.Rebuild()
+	case control.Restart:
+		return cs.Restart()
+	case control.Stop:
+		return cs.Stop()
+	case control.Start:
+		return cs.Resume()
+	case control.Pause:
+		return cs.PauseWatching()
+	case control.Resume:
+		return cs.ResumeWatching()
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// targetStatus returns the status of the named server. A server that cannot
+// be controlled is running once it has an address.
+func targetStatus(name string, target config.WebTarget, s Server) control.TargetStatus {
+	ts := control.TargetStatus{
+		Name:  name,
+		Type:  string(target.Type),
+		State: server.StateWaiting,
+	}
+
+	if addr := addrsOf(s).Addr(); addr != nil {
+		ts.Addr = addr.String()
+		ts.State = server.StateRunning
+	}
+
+	if cs, ok := s.(ControlledServer); ok {
+		status := cs.Status()
+		ts.State = status.State
+		ts.Paused = status.Paused
+	}
+
+	return ts
+}
+
+// RunCtl sends a command to the running zxstart server and shows the status
+// of the targets it reports.
+func RunCtl(cmd *cobra.Command, args []string) error {
+	config.Init(0)
+
+	req := control.Request{Command: args[0]}
+	if len(args) > 1 {
+		req.Target = args[1]
+	}
+
+	known := false
+	for _, c := range control.Commands {
+		known = known || c == req.Command
+	}
+	if !known {
+		return fmt.Errorf("unknown command %q, expected one of: %s", req.Command, strings.Join(control.Commands, ", "))
+	}
+
+	path, err := socketPath()
+	if err != nil {
+		return err
+	}
+
+	if req.Command == control.Events {
+		if req.Target != "" {
+			return errors.New("the events command does not take a target")
+		}
+
+		return control.Follow(path, func(e lifecycle.Event) error {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+
+			_, err = fmt.Printf("%s\n", data)
+			return err
+		})
+	}
+
+	resp, err := control.Send(path, &req)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tTYPE\tSTATE\tADDRESS\tWATCHING")
+	for _, ts := range resp.Targets {
+		addr := ts.Addr
+		if addr == "" {
+			addr = "-"
+		}
+
+		watching := "yes"
+		if ts.Paused {
+			watching = "paused"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", ts.Name, ts.Type, ts.State, addr, watching)
+	}
+
+	return tw.Flush()
+}
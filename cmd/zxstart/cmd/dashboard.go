@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/zostay/dev-tools/internal/tui"
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+// dashboardActions carries out the actions of the keys of the dashboard.
+type dashboardActions struct {
+	workers map[string]Server
+}
+
+// controlled returns the named server if it can be controlled.
+func (a *dashboardActions) controlled(name string) (ControlledServer, error) {
+	cs, ok := a.workers[name].(ControlledServer)
+	if !ok {
+		return nil, fmt.Errorf("web target %q cannot be controlled", name)
+	}
+	return cs, nil
+}
+
+// Rebuild builds and restarts the named server.
+func (a *dashboardActions) Rebuild(name string) error {
+	cs, err := a.controlled(name)
+	if err != nil {
+		return err
+	}
+	return cs.Rebuild()
+}
+
+// Restart restarts the named server without building it.
+func (a *dashboardActions) Restart(name string) error {
+	cs, err := a.controlled(name)
+	if err != nil {
+		return err
+	}
+	return cs.Restart()
+}
+
+// Open opens a web browser to the address of the named server.
+func (a *dashboardActions) Open(name string) error {
+	s, ok := a.workers[name]
+	if !ok {
+		return fmt.Errorf("web target %q has no address", name)
+	}
+
+	addr := addrsOf(s).Addr()
+	if addr == nil {
+		return fmt.Errorf("web target %q has no address yet", name)
+	}
+
+	return openBrowser(addr)
+}
+
+// newDashboard makes the dashboard for the servers. From now on, it is given
+// the output of the servers in place of the terminal and keeps up with what
+// happens to them.
+func newDashboard(
+	order []string,
+	targets map[string]config.WebTarget,
+	workers map[string]Server,
+) *tui.Dashboard {
+	ts := make([]tui.Target, 0, len(order))
+	for _, name := range order {
+		if _, ok := workers[name]; ok {
+			ts = append(ts, tui.Target{Name: name, Type: string(targets[name].Type)})
+		}
+	}
+
+	d := tui.New(ts, &dashboardActions{workers: workers})
+	feed.Subscribe(d.Event)
+	mux.Capture(d.Record)
+
+	return d
+}
+
+// showDashboard shows the dashboard until it is quit or a signal is received.
+// The output of the servers goes back to the terminal once the dashboard is
+// gone.
+func showDashboard(d *tui.Dashboard, sigs <-chan os.Signal) error {
+	defer mux.Capture(nil)
+
+	var (
+		quit = make(chan struct{})
+		done = make(chan struct{})
+	)
+
+	go func() {
+		select {
+		case <-sigs:
+			close(quit)
+		case <-done:
+		}
+	}()
+
+	err := d.Run(os.Stdin, os.Stdout, quit)
+	close(done)
+
+	return err
+}
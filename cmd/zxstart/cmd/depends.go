@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"net"
+	"sync"
+
+	"github.com/zostay/dev-tools/pkg/lifecycle"
+)
+
+// addrBroadcast reads the addresses published by a single Server and fans
+// them out to any number of subscribers. The AddrListener() channel of a
+// Server may only have a single reader, so everything in zxstart that cares
+// about where a server is listening must subscribe here instead.
+type addrBroadcast struct {
+	lock  *sync.Mutex
+	addr  net.Addr
+	ready chan struct{}
+	subs  []chan net.Addr
+}
+
+// newAddrBroadcast starts reading addresses from the given server.
+func newAddrBroadcast(s Server) *addrBroadcast {
+	b := &addrBroadcast{
+		lock:  new(sync.Mutex),
+		ready: make(chan struct{}),
+		subs:  make([]chan net.Addr, 0),
+	}
+
+	go func() {
+		for addr := range s.AddrListener() {
+			b.publish(addr)
+		}
+	}()
+
+	return b
+}
+
+// publish records the latest address and passes it along to the subscribers.
+func (b *addrBroadcast) publish(addr net.Addr) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.addr == nil {
+		close(b.ready)
+	}
+
+	b.addr = addr
+	for _, sub := range b.subs {
+		deliver(sub, addr)
+	}
+}
+
+// deliver replaces any undelivered address waiting in the subscriber channel
+// with the given address. Subscribers only ever care about the latest address.
+func deliver(sub chan net.Addr, addr net.Addr) {
+	select {
+	case <-sub:
+	default:
+	}
+	sub <- addr
+}
+
+// Subscribe returns a channel that will receive the addresses the server
+// publishes from now on. If the server has already published an address, that
+// is available immediately. A slow subscriber only sees the latest address.
+func (b *addrBroadcast) Subscribe() <-chan net.Addr {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	sub := make(chan net.Addr, 1)
+	b.subs = append(b.subs, sub)
+
+	if b.addr != nil {
+		deliver(sub, b.addr)
+	}
+
+	return sub
+}
+
+// Ready returns a channel that is closed once the server has published its
+// first address.
+func (b *addrBroadcast) Ready() <-chan struct{} {
+	return b.ready
+}
+
+// Addr returns the most recently published address or nil if none has been
+// published yet.
+func (b *addrBroadcast) Addr() net.Addr {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.addr
+}
+
+var (
+	broadcastsLock = new(sync.Mutex)
+	broadcasts     = make(map[Server]*addrBroadcast)
+)
+
+// addrsOf returns the address broadcast for the given server, starting one if
+// the server does not have one yet.
+func addrsOf(s Server) *addrBroadcast {
+	broadcastsLock.Lock()
+	defer broadcastsLock.Unlock()
+
+	b, ok := broadcasts[s]
+	if !ok {
+		b = newAddrBroadcast(s)
+		broadcasts[s] = b
+	}
+
+	return b
+}
+
+// startAfterDepends starts the named server once every server it depends upon
+// has published an address. If the server is a DependentServer, it is told
+// those addresses before it starts and again whenever they change. The server
+// is not started if stopping is closed first. The startLock is held while
+// starting, so that stopping is not closed mid-start.
+func startAfterDepends(
+	name string,
+	s Server,
+	depends []string,
+	workers map[string]Server,
+	startLock *sync.Mutex,
+	stopping <-chan struct{},
+) {
+	for _, dep := range depends {
+		b := addrsOf(workers[dep])
+		select {
+		case <-b.Ready():
+		default:
+			logger.Printf("Server %s is waiting for %s ...\n", name, dep)
+			feed.Publish(lifecycle.Event{Target: name, Phase: lifecycle.Waiting})
+			select {
+			case <-b.Ready():
+			case <-stopping:
+				return
+			}
+		}
+	}
+
+	startLock.Lock()
+	defer startLock.Unlock()
+
+	select {
+	case <-stopping:
+		return
+	default:
+	}
+
+	ds, isDependent := s.(DependentServer)
+	if isDependent {
+		for _, dep := range depends {
+			ds.SetDependencyAddr(dep, addrsOf(workers[dep]).Addr())
+		}
+	}
+
+	logger.Printf("Starting server %s ... \n", name)
+
+	s.Start()
+
+	if isDependent {
+		for _, dep := range depends {
+			go func(dep string) {
+				for addr := range addrsOf(workers[dep]).Subscribe() {
+					ds.SetDependencyAddr(dep, addr)
+				}
+			}(dep)
+		}
+	}
+}
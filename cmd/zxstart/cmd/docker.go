@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"sync"
+
+	"github.com/zostay/dev-tools/internal/docker"
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+func initDockerTarget(
+	name string,
+	target config.WebTarget,
+	done *sync.WaitGroup,
+) (Server, error) {
+	c, err := docker.New(mux.Source(name), feed, name, &target, done)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
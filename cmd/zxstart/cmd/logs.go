@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zostay/dev-tools/internal/output"
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [target]",
+	Short: "Show the logged output of the servers",
+	Long: `Show the logged output of the servers.
+
+The output of every target is shown unless a target is named. This works while
+zxstart server is running, too. Use -f to keep showing output as it is logged.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: RunLogs,
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var (
+	follow bool   // whether to keep showing output as it is logged
+	since  string // only show output logged since this time or duration ago
+	grep   string // only show lines matching this pattern
+)
+
+// followInterval is how often to look for more output when following.
+const followInterval = 250 * time.Millisecond
+
+// init sets up the logs command.
+func init() {
+	flags := logsCmd.Flags()
+	flags.BoolVarP(&follow, "follow", "f", false, "keep showing output as it is logged")
+	flags.StringVar(&since, "since", "", "only show output since a time (RFC 3339) or a duration ago, e.g., 10m")
+	flags.StringVar(&grep, "grep", "", "only show lines matching the regular expression")
+	flags.StringVar(&color, "color", "auto", "whether to color the output: auto, always, or never")
+}
+
+// logFilter decides which records to show.
+type logFilter struct {
+	since   time.Time
+	pattern *regexp.Regexp
+}
+
+// newLogFilter makes the filter given by the --since and --grep flags.
+func newLogFilter() (*logFilter, error) {
+	var f logFilter
+
+	if since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			f.since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			f.since = t
+		} else {
+			return nil, fmt.Errorf("--since must be a duration or an RFC 3339 time, not %q", since)
+		}
+	}
+
+	if grep != "" {
+		pattern, err := regexp.Compile(grep)
+		if err != nil {
+			return nil, fmt.Errorf("--grep must be a regular expression: %w", err)
+		}
+		f.pattern = pattern
+	}
+
+	return &f, nil
+}
+
+// Show returns true if the record is to be shown.
+func (f *logFilter) Show(r output.Record) bool {
+	if r.Time.Before(f.since) {
+		return false
+	}
+
+	return f.pattern == nil || f.pattern.MatchString(r.Text)
+}
+
+// logSources returns the names of the sources with log files in the directory.
+func logSources(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+logSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = strings.TrimSuffix(filepath.Base(path), logSuffix)
+	}
+
+	return names, nil
+}
+
+// RunLogs shows the logged output of the targets, then keeps showing more as
+// it is logged when following.
+func RunLogs(cmd *cobra.Command, args []string) error {
+	if err := setupColor(); err != nil {
+		return err
+	}
+	mux.SetTimestamps(true)
+
+	filter, err := newLogFilter()
+	if err != nil {
+		return err
+	}
+
+	config.Init(0)
+
+	dir, err := config.LogDir()
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	if len(args) > 0 {
+		name := strings.ToLower(args[0])
+		if _, err := os.Stat(logPath(dir, name)); err != nil {
+			return fmt.Errorf("there are no logs for target %q", args[0])
+		}
+		names = []string{name}
+	} else if names, err = logSources(dir); err != nil {
+		return err
+	}
+
+	// gather everything logged so far, so all of it can be shown in order
+	var (
+		records []output.Record
+		tails   = make(map[string]*output.Tail, len(names))
+	)
+
+	for _, name := range names {
+		path := logPath(dir, name)
+		for _, rpath := range output.RotatedLogFiles(path) {
+			err := output.ReadLog(rpath, name, func(r output.Record) error {
+				records = append(records, r)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		t := output.NewTail(path, name)
+		defer t.Close()
+		tails[name] = t
+
+		rs, err := t.Read()
+		if err != nil {
+			return err
+		}
+		records = append(records, rs...)
+	}
+
+	showLogs(records, filter)
+
+	if !follow {
+		return nil
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	tick := time.NewTicker(followInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			// show targets that start logging after we do, too
+			if len(args) == 0 {
+				if names, err = logSources(dir); err != nil {
+					return err
+				}
+
+				for _, name := range names {
+					if _, ok := tails[name]; !ok {
+						t := output.NewTail(logPath(dir, name), name)
+						defer t.Close()
+						tails[name] = t
+					}
+				}
+			}
+
+			records = records[:0]
+			for _, t := range tails {
+				rs, err := t.Read()
+				if err != nil {
+					return err
+				}
+				records = append(records, rs...)
+			}
+
+			showLogs(records, filter)
+
+		case <-sigs:
+			return nil
+		}
+	}
+}
+
+// showLogs shows the records that pass the filter in the order they were
+// logged.
+func showLogs(records []output.Record, filter *logFilter) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+
+	for _, r := range records {
+		if filter.Show(r) {
+			_ = mux.WriteRecord(r)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+type (
+	// InitServerFunc constructs the Server for the named web target.
+	InitServerFunc func(string, config.WebTarget, *sync.WaitGroup) (Server, error)
+
+	// ConfigServerFunc configures the Server for the named web target after
+	// every target has been initialized. The map of all the servers by target
+	// name is passed so that targets may be connected to one another.
+	ConfigServerFunc func(string, config.WebTarget, *sync.WaitGroup, map[string]Server) error
+)
+
+// TargetType describes how to construct and configure the Server for a web
+// target type.
+type TargetType struct {
+	Init   InitServerFunc
+	Config ConfigServerFunc
+}
+
+var (
+	targetTypesLock = new(sync.RWMutex)
+	targetTypes     = make(map[config.WebTargetType]TargetType)
+)
+
+// init registers the built-in web target types.
+func init() {
+	// servers are complete app servers on their own port
+	RegisterTargetType(config.ServerTarget, initServerTarget, configServerTarget)
+
+	// front-ends are ingress servers that route calls by prefix
+	RegisterTargetType(config.FrontendTarget, initFrontendTarget, configFrontendTarget)
+
+	// statics are directories of files served by zxstart itself
+	RegisterTargetType(config.StaticTarget, initStaticTarget, nil)
+
+	// dockers are containers run with the docker CLI
+	RegisterTargetType(config.DockerTarget, initDockerTarget, nil)
+}
+
+// RegisterTargetType makes a web target type available to zxstart. If the type
+// has already been registered, the new functions replace the old ones. The
+// configure function may be nil if the type needs no configuration step.
+func RegisterTargetType(
+	typ config.WebTargetType,
+	initialize InitServerFunc,
+	configure ConfigServerFunc,
+) {
+	if initialize == nil {
+		panic(fmt.Sprintf("web target type %q registered without an init function", typ))
+	}
+
+	targetTypesLock.Lock()
+	defer targetTypesLock.Unlock()
+
+	targetTypes[typ] = TargetType{
+		Init:   initialize,
+		Config: configure,
+	}
+}
+
+// LookupTargetType returns the registered functions for the given web target
+// type. The boolean is false if no such type has been registered.
+func LookupTargetType(typ config.WebTargetType) (TargetType, bool) {
+	targetTypesLock.RLock()
+	defer targetTypesLock.RUnlock()
+
+	tt, ok := targetTypes[typ]
+	return tt, ok
+}
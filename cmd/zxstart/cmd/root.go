@@ -12,6 +12,8 @@ var rootCmd = &cobra.Command{
 // init sets up the zxstart command.
 func init() {
 	rootCmd.AddCommand(serverCmd)
+	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(ctlCmd)
 }
 
 // Execute runst eh zxstart command.
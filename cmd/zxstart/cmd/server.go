@@ -1,27 +1,38 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"sync"
+	"syscall"
 
 	"github.com/spf13/cobra"
 
 	"github.com/zostay/dev-tools/internal/fswatch"
 	"github.com/zostay/dev-tools/internal/gohttp"
 	"github.com/zostay/dev-tools/internal/netx"
+	"github.com/zostay/dev-tools/internal/output"
 	"github.com/zostay/dev-tools/internal/server"
+	"github.com/zostay/dev-tools/internal/tui"
 	"github.com/zostay/dev-tools/pkg/config"
+	"github.com/zostay/dev-tools/pkg/lifecycle"
 )
 
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Start application server(s)",
 	RunE:  RunServer,
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
 }
 
 // Server is the interface used to implement each type of application server
@@ -34,18 +45,148 @@ type Server interface {
 	// Start starts the application server.
 	Start()
 
-	// Quit tells teh application server to shutdown.
-	Quit()
+	// Quit tells the application server to shutdown and waits for it to
+	// stop. It returns an error if it did not stop cleanly.
+	Quit() error
+}
+
+// DependentServer is implemented by a Server that wants to know the addresses
+// of the servers it depends upon. SetDependencyAddr is called with the address
+// of each dependency before Start and again whenever one of them changes.
+type DependentServer interface {
+	Server
+
+	// SetDependencyAddr tells the application server where the named target
+	// it depends upon is listening.
+	SetDependencyAddr(name string, addr net.Addr)
 }
 
-var logger = log.New(os.Stderr, "", 0)
+var (
+	timestamps bool   // whether to put the time before each line of output
+	color      string // whether to color the output: auto, always, or never
+	dashboard  bool   // whether to show the dashboard instead of the output
+	events     string // the format to write lifecycle events in, if any
+)
+
+// eventsJSONL is the --events format writing a line of JSON per event.
+const eventsJSONL = "jsonl"
+
+// init sets up the server command.
+func init() {
+	flags := serverCmd.Flags()
+	flags.BoolVar(&timestamps, "timestamps", false, "put the time before each line of output")
+	flags.StringVar(&color, "color", "auto", "whether to color the output: auto, always, or never")
+	flags.BoolVar(&dashboard, "tui", false, "show a full-screen dashboard of the targets instead of their output")
+	flags.StringVar(&events, "events", "", "write lifecycle events to standard output as jsonl, moving all other output to standard error")
+}
 
-type (
-	InitServerFunc   func(config.WebTarget, *sync.WaitGroup) (Server, error)
-	ConfigServerFunc func(string, config.WebTarget, *sync.WaitGroup, map[string]Server) error
+var (
+	mux    = output.New(os.Stdout, os.Stderr)
+	feed   = lifecycle.NewFeed()
+	logger = log.New(os.Stderr, "", 0)
 )
 
+// setupColor turns color on or off as given by the --color flag.
+func setupColor() error {
+	switch color {
+	case "auto":
+	case "always":
+		mux.SetColor(true)
+	case "never":
+		mux.SetColor(false)
+	default:
+		return fmt.Errorf("--color must be auto, always, or never, not %q", color)
+	}
+
+	return nil
+}
+
+// setupOutput configures the output of every target and of zxstart itself
+// from the flags.
+func setupOutput() error {
+	if err := setupColor(); err != nil {
+		return err
+	}
+
+	mux.SetTimestamps(timestamps)
+	logger.SetOutput(mux.Source(output.Zx).Stderr(output.Zx))
+
+	return nil
+}
+
+// setupLogs starts keeping the output of each of the named sources in a log
+// file of its own. It returns a function that closes the log files.
+func setupLogs(cfg *config.WebLogs, names []string) (func(), error) {
+	if cfg.Disable {
+		return func() {}, nil
+	}
+
+	dir, err := config.LogDir()
+	if err != nil {
+		return nil, err
+	}
+
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = config.DefaultLogMaxSize
+	}
+
+	maxFiles := cfg.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = config.DefaultLogMaxFiles
+	}
+
+	files := make([]*output.LogFile, 0, len(names))
+	closeAll := func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}
+
+	for _, name := range names {
+		f, err := output.OpenLogFile(logPath(dir, name), maxSize, maxFiles)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+
+		files = append(files, f)
+		mux.Source(name).SetLog(f)
+	}
+
+	return closeAll, nil
+}
+
+// logPath returns the path of the log file of the named source.
+func logPath(dir, name string) string {
+	return filepath.Join(dir, name+logSuffix)
+}
+
+// logSuffix is the suffix of the name of every log file.
+const logSuffix = ".log"
+
 func RunServer(cmd *cobra.Command, args []string) error {
+	if err := setupOutput(); err != nil {
+		return err
+	}
+
+	if dashboard && (!tui.IsTerminal(os.Stdin) || !tui.IsTerminal(os.Stdout)) {
+		return errors.New("--tui needs a terminal to show the dashboard on")
+	}
+
+	switch events {
+	case "":
+	case eventsJSONL:
+		if dashboard {
+			return errors.New("--events cannot be used with --tui")
+		}
+
+		mux.SetOutput(os.Stderr, os.Stderr)
+		feed.Subscribe(lifecycle.JSONLines(os.Stdout))
+	default:
+		return fmt.Errorf("--events must be %q, not %q", eventsJSONL, events)
+	}
+
 	config.Init(0)
 
 	cfg, err := config.Get()
@@ -53,36 +194,50 @@ func RunServer(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	order, err := cfg.Web.TargetOrder()
+	if err != nil {
+		return err
+	}
+
+	closeLogs, err := setupLogs(&cfg.Web.Logs, append([]string{output.Zx}, order...))
+	if err != nil {
+		return err
+	}
+	defer closeLogs()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
 	var (
 		workers = make(map[string]Server)
 		done    = new(sync.WaitGroup)
+		types   = make(map[string]TargetType)
 
-		initialize InitServerFunc
-		configure  ConfigServerFunc
+		startLock = new(sync.Mutex)
+		stopping  = make(chan struct{})
 	)
 
-	// prepare
-	for _, target := range cfg.Web.Targets {
-		switch target.Type {
-		// servers are complete app servers on their own port
-		case config.ServerTarget:
-			initialize = initServerTarget
-			configure = configServerTarget
-
-		// front-ends are ingress servers that route calls by prefix
-		case config.FrontendTarget:
-			initialize = initFrontendTarget
-			configure = configFrontendTarget
-
-		default:
+	// prepare - find the type of each target
+	for _, name := range order {
+		target := cfg.Web.Targets[name]
+		tt, ok := LookupTargetType(target.Type)
+		if !ok {
 			logger.Printf("Web target type %q is not supported.\n", target.Type)
 			continue
 		}
+
+		types[name] = tt
 	}
 
 	// init - construct and prep each server
-	for name, target := range cfg.Web.Targets {
-		s, err := initialize(target, done)
+	for _, name := range order {
+		tt, ok := types[name]
+		if !ok {
+			continue
+		}
+
+		target := cfg.Web.Targets[name]
+		s, err := tt.Init(name, target, done)
 		if err != nil {
 			go stopEverything(workers)
 			done.Wait()
@@ -90,11 +245,33 @@ func RunServer(cmd *cobra.Command, args []string) error {
 		}
 
 		workers[name] = s
+		addrsOf(s)
+	}
+
+	// every dependency must be something we are able to start
+	for _, name := range order {
+		if _, ok := workers[name]; !ok {
+			continue
+		}
+
+		for _, dep := range cfg.Web.Targets[name].DependsOn() {
+			if _, ok := workers[dep]; !ok {
+				go stopEverything(workers)
+				done.Wait()
+				return fmt.Errorf("web target %q depends on web target %q, which cannot be started", name, dep)
+			}
+		}
 	}
 
 	// config - tell each server what it is going to do
-	for name, target := range cfg.Web.Targets {
-		err := configure(name, target, done, workers)
+	for _, name := range order {
+		tt, ok := types[name]
+		if !ok || tt.Config == nil {
+			continue
+		}
+
+		target := cfg.Web.Targets[name]
+		err := tt.Config(name, target, done, workers)
 		if err != nil {
 			go stopEverything(workers)
 			done.Wait()
@@ -102,46 +279,53 @@ func RunServer(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	// process - start each server
-	for name, server := range workers {
-		logger.Printf("Starting server %s ... \n", name)
+	// control - let zxstart ctl reach the servers
+	stopControl, err := setupControl(order, cfg.Web.Targets, workers, done)
+	if err != nil {
+		go stopEverything(workers)
+		done.Wait()
+		return err
+	}
+	defer stopControl()
+
+	// the dashboard must see everything from the start
+	var dash *tui.Dashboard
+	if dashboard {
+		dash = newDashboard(order, cfg.Web.Targets, workers)
+	}
+
+	// process - start each server once its dependencies are up
+	for _, name := range order {
+		s, ok := workers[name]
+		if !ok {
+			continue
+		}
 
-		server.Start()
+		done.Add(1)
+		go func(name string, s Server) {
+			defer done.Done()
+			startAfterDepends(name, s, cfg.Web.Targets[name].DependsOn(), workers, startLock, stopping)
+		}(name, s)
 	}
 
 	// post-process - connect the server to the hoomin
-	for name, target := range cfg.Web.Targets {
-		if target.Type != config.FrontendTarget && target.Type != config.ServerTarget {
-			logger.Printf("Web target type %q is not supported.\n", target.Type)
+	for _, name := range order {
+		target := cfg.Web.Targets[name]
+		s, ok := workers[name]
+		if !ok {
+			continue
 		}
 
-		if target.OpenBrowser {
-			s := workers[name]
-
-			var openCmdName string
-			switch runtime.GOOS {
-			case "darwin":
-				openCmdName = "open"
-			case "linux":
-				openCmdName = "xdg-open"
-			default:
-				panic("unsupported OS for open_browser")
+		go func(name string, s Server) {
+			for addr := range addrsOf(s).Subscribe() {
+				feed.Publish(lifecycle.Event{Target: name, Phase: lifecycle.Ready, Addr: addr.String()})
 			}
+		}(name, s)
 
+		if target.OpenBrowser {
 			go func(s Server) {
-				for {
-					addr := <-s.AddrListener()
-					url, err := netx.AddrToURL(addr.String())
-					if err != nil {
-						logger.Printf("Failed to turn address %q into URL: %v", addr, err)
-						return
-					}
-
-					logger.Printf("Opening browser to %q", url.String())
-
-					openCmd := exec.Command(openCmdName, url.String())
-					err = openCmd.Run()
-					if err != nil {
+				for addr := range addrsOf(s).Subscribe() {
+					if err := openBrowser(addr); err != nil {
 						logger.Printf("Failed to open browser to %q: %v", addr, err)
 					}
 				}
@@ -149,17 +333,58 @@ func RunServer(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	// sticks here
-	done.Wait()
+	// sticks here, until interrupted or the dashboard is quit
+	if dash != nil {
+		if err := showDashboard(dash, sigs); err != nil {
+			logger.Printf("Dashboard error: %v\n", err)
+		}
+		logger.Printf("Shutting down ...\n")
+	} else {
+		sig := <-sigs
+		logger.Printf("Received %v, shutting down ...\n", sig)
+	}
 
-	return nil
+	go func() {
+		sig := <-sigs
+		logger.Printf("Received %v again, exiting immediately.\n", sig)
+		os.Exit(1)
+	}()
+
+	startLock.Lock()
+	close(stopping)
+	startLock.Unlock()
+
+	return shutdown(order, workers)
+}
+
+// openBrowser opens a web browser to the address.
+func openBrowser(addr net.Addr) error {
+	var openCmdName string
+	switch runtime.GOOS {
+	case "darwin":
+		openCmdName = "open"
+	case "linux":
+		openCmdName = "xdg-open"
+	default:
+		return fmt.Errorf("opening a browser is not supported on %s", runtime.GOOS)
+	}
+
+	url, err := netx.AddrToURL(addr.String())
+	if err != nil {
+		return fmt.Errorf("unable to turn address into URL: %w", err)
+	}
+
+	logger.Printf("Opening browser to %q", url.String())
+
+	return exec.Command(openCmdName, url.String()).Run()
 }
 
 func initServerTarget(
+	name string,
 	target config.WebTarget,
 	done *sync.WaitGroup,
 ) (Server, error) {
-	w, err := server.NewWorker(logger, &target, done)
+	w, err := server.NewWorker(mux.Source(name), feed, &target, nil, done)
 	if err != nil {
 		return nil, err
 	}
@@ -176,7 +401,7 @@ func configServerTarget(
 	w := workers[name].(*server.Worker)
 
 	for _, wcfg := range target.Watches {
-		q, err := fswatch.SetupWatcher(w, done, &wcfg)
+		q, err := fswatch.SetupWatcher(w.Watcher(wcfg), done, &wcfg, target.WorkingDir)
 		if err != nil {
 			stopEverything(workers)
 			return err
@@ -189,10 +414,11 @@ func configServerTarget(
 }
 
 func initFrontendTarget(
+	name string,
 	target config.WebTarget,
 	done *sync.WaitGroup,
 ) (Server, error) {
-	f := gohttp.New(done, logger)
+	f := gohttp.New(done, mux.Source(name).Logger())
 
 	return f, nil
 }
@@ -214,9 +440,7 @@ func configFrontendTarget(
 		done.Add(1)
 		go func(path string, w Server) {
 			defer done.Done()
-			for {
-				addr := <-w.AddrListener()
-
+			for addr := range addrsOf(w).Subscribe() {
 				url := url.URL{
 					Scheme: "http",
 					Host:   addr.String(),
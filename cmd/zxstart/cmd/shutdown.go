@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+)
+
+// shutdown quits every server in reverse dependency order, waiting for each to
+// stop before moving on to the servers it depends upon. It logs a summary of
+// how each server stopped and returns an error if any did not stop cleanly.
+func shutdown(order []string, workers map[string]Server) error {
+	type result struct {
+		name string
+		err  error
+	}
+
+	var (
+		results = make([]result, 0, len(workers))
+		width   = 0
+	)
+
+	for i := len(order) - 1; i >= 0; i-- {
+		name := order[i]
+		s, ok := workers[name]
+		if !ok {
+			continue
+		}
+
+		logger.Printf("Stopping server %s ...\n", name)
+		results = append(results, result{name, s.Quit()})
+
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	failed := 0
+	logger.Println("Shutdown summary:")
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			logger.Printf("  %-*s  did not stop cleanly: %v\n", width, r.name, r.err)
+		} else {
+			logger.Printf("  %-*s  stopped cleanly\n", width, r.name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d web targets did not stop cleanly", failed, len(results))
+	}
+
+	return nil
+}
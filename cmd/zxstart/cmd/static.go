@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zostay/dev-tools/internal/discovery"
+	"github.com/zostay/dev-tools/internal/gohttp"
+	"github.com/zostay/dev-tools/internal/output"
+	"github.com/zostay/dev-tools/internal/targetcmd"
+	"github.com/zostay/dev-tools/pkg/acmd"
+	"github.com/zostay/dev-tools/pkg/config"
+	"github.com/zostay/dev-tools/pkg/lifecycle"
+)
+
+// staticServer is the Server for static web targets. It runs the build
+// commands of the target, if any, and then serves the files.
+type staticServer struct {
+	*gohttp.Static
+
+	name   string
+	target config.WebTarget
+	done   *sync.WaitGroup
+	logger *log.Logger
+	out    *output.Source
+	feed   *lifecycle.Feed
+
+	depends *discovery.Addrs
+
+	lock     *sync.Mutex
+	started  bool
+	quitting bool
+	builder  *acmd.Cmd
+}
+
+func initStaticTarget(
+	name string,
+	target config.WebTarget,
+	done *sync.WaitGroup,
+) (Server, error) {
+	root := target.Static.Root
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(target.WorkingDir, root)
+	}
+
+	if root == "" {
+		root = "."
+	}
+
+	out := mux.Source(name)
+	s := &staticServer{
+		Static: gohttp.NewStatic(done, out.Logger(), root, &target.Static),
+
+		name:   name,
+		target: target,
+		done:   done,
+		logger: out.Logger(),
+		out:    out,
+		feed:   feed,
+
+		depends: discovery.NewAddrs(),
+
+		lock: new(sync.Mutex),
+	}
+
+	if _, err := target.Environ(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// build runs the build commands, if any, stopping any build already running.
+func (s *staticServer) build() error {
+	if len(s.target.Build) == 0 {
+		return nil
+	}
+
+	s.stopBuild()
+
+	b, err := acmd.Command(s.target.WorkingDir, s.target.Build, s.done, s.logger)
+	if err != nil {
+		return err
+	}
+
+	if err := targetcmd.Configure(b, s.name, &s.target); err != nil {
+		return err
+	}
+
+	b.EnvHandler = s.depends.Environ
+	b.Stdout = s.out.Stdout(output.Build)
+	b.Stderr = s.out.Stderr(output.Build)
+
+	s.lock.Lock()
+	if s.quitting {
+		s.lock.Unlock()
+		return nil
+	}
+	s.builder = b
+	s.lock.Unlock()
+
+	start := time.Now()
+	s.publish(lifecycle.Event{Phase: lifecycle.BuildStart})
+
+	err = b.Run()
+
+	s.lock.Lock()
+	if s.builder == b {
+		s.builder = nil
+	}
+	s.lock.Unlock()
+
+	phase := lifecycle.BuildOK
+	if err != nil {
+		phase = lifecycle.BuildFail
+	}
+
+	s.publish(lifecycle.Event{
+		Phase:    phase,
+		Duration: time.Since(start),
+		ExitCode: acmd.ExitCode(err),
+		Err:      err,
+	})
+
+	return err
+}
+
+// publish sends the event to the feed on behalf of the target.
+func (s *staticServer) publish(e lifecycle.Event) {
+	e.Target = s.name
+	s.feed.Publish(e)
+}
+
+// stopBuild stops the build, if one is running, and waits for it to quit.
+func (s *staticServer) stopBuild() error {
+	s.lock.Lock()
+	builder := s.builder
+	s.builder = nil
+	s.lock.Unlock()
+
+	if builder == nil {
+		return nil
+	}
+
+	builder.Stop()
+	return builder.Wait()
+}
+
+// SetDependencyAddr tells the static server the address of the named target it
+// depends upon, which is passed to the build commands in the environment. If
+// the server has started and the address has changed, the files are built
+// again.
+func (s *staticServer) SetDependencyAddr(name string, addr net.Addr) {
+	changed := s.depends.Set(name, addr)
+
+	s.lock.Lock()
+	started := s.started
+	s.lock.Unlock()
+
+	if !started || !changed || len(s.target.Build) == 0 {
+		return
+	}
+
+	s.logger.Printf("Dependency %s is now at %s, rebuilding ...\n", name, addr)
+
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		if err := s.build(); err != nil {
+			s.logger.Printf("Build for static server %s failed: %v\n", s.name, err)
+		}
+	}()
+}
+
+// Start runs the build, if there is one, and then starts serving files.
+func (s *staticServer) Start() {
+	s.lock.Lock()
+	s.started = true
+	s.lock.Unlock()
+
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+
+		if err := s.build(); err != nil {
+			s.logger.Printf("Build for static server %s failed, serving files anyway: %v\n", s.name, err)
+		}
+
+		listen := s.target.Static.Listen
+		if listen == "" {
+			listen = ":0"
+		}
+
+		l, err := net.Listen("tcp", listen)
+		if err != nil {
+			s.logger.Printf("Static server %s is unable to listen on %q: %v\n", s.name, listen, err)
+			return
+		}
+
+		s.publish(lifecycle.Event{Phase: lifecycle.RunStart})
+
+		err = s.Serve(l)
+		if err != nil {
+			s.logger.Printf("Static server %s error: %v\n", s.name, err)
+		}
+
+		s.publish(lifecycle.Event{Phase: lifecycle.Exit, ExitCode: acmd.ExitCode(err), Err: err})
+	}()
+}
+
+// Quit stops the build, if it is still running, and shuts the file server
+// down.
+func (s *staticServer) Quit() error {
+	s.lock.Lock()
+	s.quitting = true
+	s.lock.Unlock()
+
+	var err error
+	if berr := s.stopBuild(); errors.Is(berr, acmd.ErrStopTimeout) {
+		err = berr
+	}
+
+	if serr := s.Static.Quit(); serr != nil {
+		err = serr
+	}
+
+	return err
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"github.com/spf13/cobra"
+
 	"github.com/zostay/dev-tools/cmd/zxstart/cmd"
 )
 
+// main runs the zxstart command.
 func main() {
-	if err := cmd.Execute(); err != nil {
-		panic(err)
-	}
+	err := cmd.Execute()
+	cobra.CheckErr(err)
 }
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zostay/dev-tools/internal/fswatch"
+	"github.com/zostay/dev-tools/pkg/acmd"
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "zxwatch [flags] [name | -- command [args...]]",
+	Short: "Run a command every time files change.",
+	Long: `Run a command every time files change.
+
+Either give the command to run after --, or the name of a watch defined under
+watches in .zx.yaml. Flags given override the settings of a named watch.`,
+	RunE: RunWatch,
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var (
+	targets      []string      // the files and directories to watch
+	goPackage    string        // the Go package to watch instead of targets
+	includes     []string      // patterns of the paths to watch
+	excludes     []string      // patterns of the paths to ignore
+	ignoreFiles  bool          // whether to honor .gitignore and .zxignore
+	ops          []string      // the kinds of changes to watch for
+	backend      string        // how to watch for changes
+	pollInterval time.Duration // how often to poll for changes
+	contentHash  bool          // whether to ignore changes not changing content
+	debounce     time.Duration // how long to wait for changes to settle
+	restart      bool          // whether the command keeps running
+)
+
+// defaultDebounce is how long to wait for changes to settle before running
+// the command, unless configured otherwise.
+const defaultDebounce = 250 * time.Millisecond
+
+var logger = log.New(os.Stderr, "", 0)
+
+// init sets up the zxwatch command.
+func init() {
+	flags := rootCmd.Flags()
+	flags.StringSliceVarP(&targets, "watch", "w", []string{"."}, "file or directory to watch")
+	flags.StringVarP(&goPackage, "go-package", "g", "", "watch the files a Go package is built from")
+	flags.StringSliceVarP(&includes, "include", "i", nil, "only watch paths matching the pattern")
+	flags.StringSliceVarP(&excludes, "exclude", "x", nil, "ignore paths matching the pattern")
+	flags.BoolVar(&ignoreFiles, "ignore-files", false, "ignore the paths listed in .gitignore and .zxignore files")
+	flags.StringSliceVar(&ops, "ops", nil, "kinds of changes to watch for: create, write, remove, rename, or chmod")
+	flags.StringVar(&backend, "backend", "", "how to watch for changes: auto, fsnotify, or poll")
+	flags.DurationVar(&pollInterval, "poll-interval", 0, "how often to look for changes when polling")
+	flags.BoolVar(&contentHash, "content-hash", false, "ignore changes that leave the content of a file the same")
+	flags.DurationVarP(&debounce, "debounce", "d", defaultDebounce, "how long to wait for changes to settle")
+	flags.BoolVarP(&restart, "restart", "r", false, "restart a command that keeps running on every change")
+}
+
+// Execute runs the zxwatch command.
+func Execute() error {
+	return rootCmd.Execute()
+}
+
+// watchConfig returns the watch to run as given by the arguments and flags.
+func watchConfig(cmd *cobra.Command, args []string) (*config.WatchCommand, error) {
+	var wc config.WatchCommand
+
+	dash := cmd.ArgsLenAtDash()
+	switch {
+	case dash == 0 && len(args) > 0:
+		wc.Command = args
+
+	case dash < 0 && len(args) == 1:
+		cfg, err := config.Get()
+		if err != nil {
+			return nil, err
+		}
+
+		named, ok := cfg.Watches[strings.ToLower(args[0])]
+		if !ok {
+			return nil, fmt.Errorf("there is no watch named %q in the config", args[0])
+		}
+		wc = named
+
+		if len(wc.Command) == 0 {
+			return nil, fmt.Errorf("you must set the watches.%s.command in the config", args[0])
+		}
+
+	default:
+		return nil, errors.New("expected either the name of a watch or a command to run after --")
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("go-package") {
+		wc.GoPackage = goPackage
+	}
+	if wc.GoPackage != "" {
+		if !flags.Changed("watch") {
+			wc.Targets = nil
+		} else {
+			wc.Targets = targets
+		}
+	} else if wc.Targets == nil || flags.Changed("watch") {
+		wc.Targets = targets
+	}
+	if flags.Changed("include") {
+		wc.Filters = includes
+	}
+	if flags.Changed("exclude") {
+		wc.Excludes = excludes
+	}
+	if flags.Changed("ignore-files") {
+		wc.IgnoreFiles = ignoreFiles
+	}
+	if flags.Changed("ops") {
+		wc.Ops = ops
+	}
+	if flags.Changed("backend") {
+		wc.Backend = backend
+	}
+	if flags.Changed("poll-interval") {
+		wc.PollInterval = pollInterval
+	}
+	if flags.Changed("content-hash") {
+		wc.ContentHash = contentHash
+	}
+	if wc.Debounce <= 0 || flags.Changed("debounce") {
+		wc.Debounce = debounce
+	}
+	if flags.Changed("restart") {
+		wc.Restart = restart
+	}
+
+	return &wc, nil
+}
+
+// watcher receives the file system events for zxwatch.
+type watcher struct {
+	events  chan fswatch.Event
+	errors  chan error
+	refresh chan struct{}
+}
+
+func (w *watcher) EventsListener() chan fswatch.Event {
+	return w.events
+}
+
+func (w *watcher) ErrorsListener() chan error {
+	return w.errors
+}
+
+func (w *watcher) RefreshListener() chan struct{} {
+	return w.refresh
+}
+
+// Refresh tells the watch to work out what it watches again, which matters
+// when watching a Go package.
+func (w *watcher) Refresh() {
+	select {
+	case w.refresh <- struct{}{}:
+	default:
+		// a refresh is waiting already
+	}
+}
+
+// RunWatch runs the command once at the start and then again every time the
+// watched files change, until interrupted.
+func RunWatch(cmd *cobra.Command, args []string) error {
+	config.Init(0)
+
+	wc, err := watchConfig(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	var (
+		w = watcher{
+			events:  make(chan fswatch.Event),
+			errors:  make(chan error),
+			refresh: make(chan struct{}, 1),
+		}
+		done = new(sync.WaitGroup)
+	)
+
+	quit, err := fswatch.SetupWatcher(&w, done, &wc.FileWatch, "")
+	if err != nil {
+		return err
+	}
+	defer quit()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	var (
+		running  *acmd.Cmd
+		stopped  bool
+		pending  bool
+		finished = make(chan error, 1)
+		changes  = make(map[string]struct{})
+		settle   *time.Timer
+	)
+
+	run := func() {
+		c, err := acmd.Command(".", wc.Command, done, logger)
+		if err != nil {
+			panic(err)
+		}
+
+		running, stopped = c, false
+		c.Start()
+		go func() { finished <- c.Wait() }()
+	}
+
+	run()
+	for {
+		var settled <-chan time.Time
+		if settle != nil {
+			settled = settle.C
+		}
+
+		select {
+		case e := <-w.events:
+			changes[e.Name] = struct{}{}
+			if settle != nil && !settle.Stop() {
+				<-settle.C
+			}
+			settle = time.NewTimer(wc.Debounce)
+
+		case err := <-w.errors:
+			logger.Printf("Watch Error: %v\n", err)
+
+		case <-settled:
+			settle = nil
+			logChanges(changes)
+			changes = make(map[string]struct{})
+
+			switch {
+			case running == nil:
+				run()
+			case wc.Restart:
+				w.Refresh()
+				stopped, pending = true, true
+				running.Stop()
+			default:
+				pending = true
+			}
+
+		case err := <-finished:
+			if err != nil && !stopped {
+				logger.Printf("Command %q failed: %v\n", running.String(), err)
+			} else if err == nil {
+				w.Refresh()
+			}
+			running = nil
+
+			if pending {
+				pending = false
+				run()
+			}
+
+		case sig := <-sigs:
+			logger.Printf("Received %v, shutting down ...\n", sig)
+			if running != nil {
+				running.Stop()
+				<-finished
+			}
+			return nil
+		}
+	}
+}
+
+// logChanges logs the files that changed.
+func logChanges(changes map[string]struct{}) {
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	logger.Printf("Detected changes to %d file(s): %s\n", len(names), strings.Join(names, ", "))
+}
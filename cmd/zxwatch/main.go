@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/zostay/dev-tools/cmd/zxwatch/cmd"
+)
+
+// main runs the zxwatch command.
+func main() {
+	err := cmd.Execute()
+	cobra.CheckErr(err)
+}
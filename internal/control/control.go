@@ -0,0 +1,244 @@
+// Package control lets a running zxstart server be controlled by other
+// processes through a Unix socket. Each connection carries a single JSON
+// request followed by a single JSON response, except for the events command,
+// which is answered with a line of JSON for every lifecycle event until the
+// connection is closed.
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zostay/dev-tools/pkg/lifecycle"
+)
+
+// SocketName is the name of the control socket in the state directory.
+const SocketName = "zxstart.sock"
+
+// dialTimeout is how long a client waits to connect to the socket.
+const dialTimeout = 2 * time.Second
+
+// These are the commands a Request may give.
+const (
+	Status  = "status"  // report the status of the targets
+	Rebuild = "rebuild" // build and restart targets
+	Restart = "restart" // restart targets without building
+	Stop    = "stop"    // stop targets until started again
+	Start   = "start"   // start stopped targets again
+	Pause   = "pause"   // ignore changes to watched files
+	Resume  = "resume"  // act on changes to watched files again
+	Events  = "events"  // follow the lifecycle events of the targets
+)
+
+// Commands lists every command in the order to document them.
+var Commands = []string{Status, Rebuild, Restart, Stop, Start, Pause, Resume, Events}
+
+// eventBuffer is how many events may wait to be sent to a client following
+// events before the client is given up on as too slow.
+const eventBuffer = 1024
+
+// ErrNotRunning is returned by Send when there is no server listening on the
+// socket.
+var ErrNotRunning = errors.New("zxstart server is not running")
+
+// Request is a command sent to the server.
+type Request struct {
+	// Command is one of the command constants.
+	Command string `json:"command"`
+
+	// Target names the target to apply the command to. When empty, the
+	// command applies to every target.
+	Target string `json:"target,omitempty"`
+}
+
+// TargetStatus describes a single target of the server.
+type TargetStatus struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	State  string `json:"state"`
+	Addr   string `json:"addr,omitempty"`
+	Paused bool   `json:"paused,omitempty"`
+}
+
+// Response is the reply of the server to a request.
+type Response struct {
+	// Error is set when the command failed.
+	Error string `json:"error,omitempty"`
+
+	// Targets is the status of the targets after the command.
+	Targets []TargetStatus `json:"targets,omitempty"`
+}
+
+// Handler carries out a request and returns the response to send.
+type Handler func(req *Request) *Response
+
+// Listen starts serving requests on the socket at the given path, passing
+// each to the handler, except for the events command, which is answered with
+// the events published to the feed. A socket left behind by a server that is
+// gone is replaced, but it is an error if another server is listening on it.
+// It returns a function that stops listening and removes the socket.
+func Listen(
+	path string,
+	handle Handler,
+	feed *lifecycle.Feed,
+	done *sync.WaitGroup,
+) (func(), error) {
+	if conn, err := net.DialTimeout("unix", path, dialTimeout); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another zxstart server is listening on %s", path)
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go serve(conn, handle, feed)
+		}
+	}()
+
+	quit := func() {
+		l.Close()
+		_ = os.Remove(path)
+	}
+
+	return quit, nil
+}
+
+// serve handles the single request of a connection.
+func serve(conn net.Conn, handle Handler, feed *lifecycle.Feed) {
+	defer conn.Close()
+
+	var (
+		req  Request
+		resp *Response
+	)
+
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&req); err != nil {
+		resp = &Response{Error: fmt.Sprintf("unable to read request: %v", err)}
+	} else if req.Command == Events {
+		streamEvents(conn, dec, feed)
+		return
+	} else {
+		resp = handle(&req)
+	}
+
+	_ = json.NewEncoder(conn).Encode(resp)
+}
+
+// streamEvents sends every event published to the feed to the client until
+// the client hangs up or falls too far behind.
+func streamEvents(conn net.Conn, dec *json.Decoder, feed *lifecycle.Feed) {
+	var (
+		events = make(chan lifecycle.Event, eventBuffer)
+		gone   = make(chan struct{})
+		once   sync.Once
+	)
+
+	hangUp := func() { once.Do(func() { close(gone) }) }
+
+	unsubscribe := feed.Subscribe(func(e lifecycle.Event) {
+		select {
+		case events <- e:
+		default:
+			// the client is too slow to keep up
+			hangUp()
+		}
+	})
+	defer unsubscribe()
+
+	// the client sends nothing more, so reading only ends when it hangs up
+	go func() {
+		var discard json.RawMessage
+		_ = dec.Decode(&discard)
+		hangUp()
+	}()
+
+	enc := json.NewEncoder(conn)
+	for {
+		select {
+		case e := <-events:
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// Follow asks the server listening on the socket at the path for its lifecycle
+// events and calls the function with each of them until the function returns
+// an error or the server goes away. It returns ErrNotRunning if no server is
+// listening.
+func Follow(path string, fn func(lifecycle.Event) error) error {
+	conn, err := net.DialTimeout("unix", path, dialTimeout)
+	if err != nil {
+		return ErrNotRunning
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(&Request{Command: Events}); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(conn)
+	for {
+		var e lifecycle.Event
+		if err := dec.Decode(&e); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("unable to read event: %w", err)
+		}
+
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+// Send sends the request to the server listening on the socket at the path
+// and returns its response. It returns ErrNotRunning if no server is
+// listening. An error reported by the server is returned as an error.
+func Send(path string, req *Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", path, dialTimeout)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("unable to read response: %w", err)
+	}
+
+	if resp.Error != "" {
+		return &resp, errors.New(resp.Error)
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,101 @@
+// Package discovery tells the commands of a target where to find the targets
+// it depends upon.
+package discovery
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zostay/dev-tools/internal/netx"
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+// Env returns the environment variables that tell a server where to find the
+// named target it depends upon. For a target named api, these are
+// ZX_TARGET_API_URL, ZX_TARGET_API_HOST, and ZX_TARGET_API_PORT.
+func Env(name string, addr net.Addr) []string {
+	hostport, err := netx.AddrToHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil
+	}
+
+	url, err := netx.AddrToURL(hostport)
+	if err != nil {
+		return nil
+	}
+
+	prefix := config.ZxPrefix + "_TARGET_" + envName(name) + "_"
+	return []string{
+		prefix + "URL=" + url.String(),
+		prefix + "HOST=" + host,
+		prefix + "PORT=" + port,
+	}
+}
+
+// envName turns a target name into something usable as part of an environment
+// variable name.
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// Addrs keeps the addresses of the targets a target depends upon. It is safe
+// to use from more than one goroutine.
+type Addrs struct {
+	lock  sync.Mutex
+	addrs map[string]net.Addr
+}
+
+// NewAddrs returns an empty set of addresses.
+func NewAddrs() *Addrs {
+	return &Addrs{
+		addrs: make(map[string]net.Addr),
+	}
+}
+
+// Set sets the address of the named target. It returns true if the address
+// was not known before or has changed.
+func (a *Addrs) Set(name string, addr net.Addr) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	old, known := a.addrs[name]
+	a.addrs[name] = addr
+
+	return !known || old.String() != addr.String()
+}
+
+// Environ returns the environment variables from Env for every target, sorted
+// by target name.
+func (a *Addrs) Environ() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	names := make([]string, 0, len(a.addrs))
+	for name := range a.addrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := make([]string, 0, 3*len(names))
+	for _, name := range names {
+		env = append(env, Env(name, a.addrs[name])...)
+	}
+
+	return env
+}
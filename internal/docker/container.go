@@ -0,0 +1,534 @@
+// Package docker runs web targets as docker containers using the docker CLI.
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zostay/dev-tools/internal/discovery"
+	"github.com/zostay/dev-tools/internal/netx"
+	"github.com/zostay/dev-tools/internal/output"
+	"github.com/zostay/dev-tools/internal/targetcmd"
+	"github.com/zostay/dev-tools/pkg/acmd"
+	"github.com/zostay/dev-tools/pkg/config"
+	"github.com/zostay/dev-tools/pkg/lifecycle"
+)
+
+const defaultCommand = "docker"
+
+// Container manages the image build, container run, and log output of a
+// single docker web target.
+type Container struct {
+	logger *log.Logger
+	out    *output.Source
+	feed   *lifecycle.Feed
+
+	config *config.WebTarget
+	done   *sync.WaitGroup
+
+	docker string
+	name   string
+	image  string
+	port   string
+
+	addrs   chan net.Addr
+	depends *discovery.Addrs
+
+	lock     *sync.Mutex
+	started  bool
+	quitting bool
+	running  bool
+	builder  *acmd.Cmd
+}
+
+// New constructs the container for the named target, writing its output to the
+// given output source and publishing what happens to it to the feed. Nothing is
+// built or run until Start is called.
+func New(
+	out *output.Source,
+	feed *lifecycle.Feed,
+	name string,
+	config *config.WebTarget,
+	done *sync.WaitGroup,
+) (*Container, error) {
+	dcfg := &config.Docker
+
+	docker := dcfg.Command
+	if docker == "" {
+		docker = defaultCommand
+	}
+
+	cname := dcfg.Name
+	if cname == "" {
+		cname = defaultName(name)
+	}
+
+	image := dcfg.Image
+	if image == "" {
+		if len(config.Build) == 0 && dcfg.Dockerfile == "" && dcfg.Context == "" {
+			return nil, errors.New("you must set web.targets.….docker.image or build the image with web.targets.….build, web.targets.….docker.dockerfile, or web.targets.….docker.context in the config")
+		}
+
+		image = cname
+	}
+
+	port := dcfg.Port
+	if port == "" && len(dcfg.Ports) > 0 {
+		port = containerPort(dcfg.Ports[0])
+	}
+
+	if port == "" {
+		return nil, errors.New("you must set web.targets.….docker.ports or web.targets.….docker.port in the config")
+	}
+
+	if _, err := config.Environ(); err != nil {
+		return nil, fmt.Errorf("web.targets.%s: %w", name, err)
+	}
+
+	c := Container{
+		logger: out.Logger(),
+		out:    out,
+		feed:   feed,
+
+		config: config,
+		done:   done,
+
+		docker: docker,
+		name:   cname,
+		image:  image,
+		port:   port,
+
+		addrs:   make(chan net.Addr),
+		depends: discovery.NewAddrs(),
+
+		lock: new(sync.Mutex),
+	}
+
+	return &c, nil
+}
+
+// stopSeconds returns the timeout as whole seconds for docker stop -t, rounding
+// up so that a fraction of a second still gives the container a chance to stop
+// gracefully. A negative timeout waits for the container to stop however long
+// it takes.
+func stopSeconds(timeout time.Duration) string {
+	if timeout < 0 {
+		return "-1"
+	}
+
+	return strconv.Itoa(int(math.Ceil(timeout.Seconds())))
+}
+
+// defaultName returns a container name made from the name of the current
+// directory and the target name.
+func defaultName(target string) string {
+	project := "zx"
+	if wd, err := os.Getwd(); err == nil {
+		project = filepath.Base(wd)
+	}
+
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, project+"-"+target)
+
+	return "zx-" + strings.Trim(name, "-_.")
+}
+
+// containerPort returns the container port of a port written as for docker
+// run -p, e.g., 8080:80 or 127.0.0.1::80/tcp is port 80.
+func containerPort(p string) string {
+	ps := strings.Split(p, ":")
+	return ps[len(ps)-1]
+}
+
+// command builds a docker command with the given arguments.
+func (c *Container) command(args ...string) *exec.Cmd {
+	cmd := exec.Command(c.docker, args...)
+	cmd.Dir = c.config.WorkingDir
+	return cmd
+}
+
+// output runs a docker command and returns its trimmed standard output.
+func (c *Container) output(args ...string) (string, error) {
+	cmd := c.command(args...)
+
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", c.docker, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (c *Container) isQuitting() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.quitting
+}
+
+// publish sends the event to the feed on behalf of the target.
+func (c *Container) publish(e lifecycle.Event) {
+	e.Target = c.out.Name()
+	c.feed.Publish(e)
+}
+
+// build builds the image using the target build commands or docker build,
+// publishing the start and the result of the build, if there is one.
+func (c *Container) build() error {
+	dcfg := &c.config.Docker
+	if len(c.config.Build) == 0 && dcfg.Dockerfile == "" && dcfg.Context == "" {
+		return nil
+	}
+
+	start := time.Now()
+	c.publish(lifecycle.Event{Phase: lifecycle.BuildStart})
+
+	err := c.buildImage()
+
+	phase := lifecycle.BuildOK
+	if err != nil {
+		phase = lifecycle.BuildFail
+	}
+
+	c.publish(lifecycle.Event{
+		Phase:    phase,
+		Duration: time.Since(start),
+		ExitCode: acmd.ExitCode(err),
+		Err:      err,
+	})
+
+	return err
+}
+
+// buildImage runs the target build commands or else docker build.
+func (c *Container) buildImage() error {
+	dcfg := &c.config.Docker
+	if len(c.config.Build) > 0 {
+		b, err := acmd.Command(c.config.WorkingDir, c.config.Build, c.done, c.logger)
+		if err != nil {
+			return err
+		}
+
+		b.EnvHandler = c.depends.Environ
+		b.Stdout = c.out.Stdout(output.Build)
+		b.Stderr = c.out.Stderr(output.Build)
+
+		if err := targetcmd.Configure(b, c.out.Name(), c.config); err != nil {
+			return err
+		}
+
+		c.lock.Lock()
+		c.builder = b
+		c.lock.Unlock()
+
+		err = b.Run()
+
+		c.lock.Lock()
+		c.builder = nil
+		c.lock.Unlock()
+
+		return err
+	}
+
+	args := []string{"build", "-t", c.image}
+	if dcfg.Dockerfile != "" {
+		args = append(args, "-f", dcfg.Dockerfile)
+	}
+
+	context := dcfg.Context
+	if context == "" {
+		context = "."
+	}
+	args = append(args, context)
+
+	c.logger.Printf("Run> %s %s ...\n", c.docker, strings.Join(args, " "))
+
+	stdout, stderr := c.out.Stdout(output.Build), c.out.Stderr(output.Build)
+	defer stdout.Flush()
+	defer stderr.Flush()
+
+	env, err := c.environ()
+	if err != nil {
+		return err
+	}
+
+	cmd := c.command(args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// environ returns the environment configured for the target followed by the
+// addresses of the targets it depends upon.
+func (c *Container) environ() ([]string, error) {
+	env, err := c.config.Environ()
+	if err != nil {
+		return nil, fmt.Errorf("web.targets.%s: %w", c.out.Name(), err)
+	}
+
+	return append(env, c.depends.Environ()...), nil
+}
+
+// volume makes the host path of a volume written as for docker run -v
+// absolute if it is a relative path.
+func (c *Container) volume(v string) string {
+	vs := strings.SplitN(v, ":", 2)
+	if len(vs) < 2 || !strings.HasPrefix(vs[0], ".") {
+		return v
+	}
+
+	host, err := filepath.Abs(filepath.Join(c.config.WorkingDir, vs[0]))
+	if err != nil {
+		return v
+	}
+
+	return host + ":" + vs[1]
+}
+
+// run removes any stale container of the same name and runs a new one.
+func (c *Container) run() error {
+	dcfg := &c.config.Docker
+
+	env, err := c.config.Environ()
+	if err != nil {
+		return fmt.Errorf("web.targets.%s: %w", c.out.Name(), err)
+	}
+
+	_, _ = c.output("rm", "-f", c.name)
+
+	args := []string{"run", "-d", "--name", c.name}
+	for _, p := range dcfg.Ports {
+		args = append(args, "-p", p)
+	}
+	for _, v := range dcfg.Volumes {
+		args = append(args, "-v", c.volume(v))
+	}
+	for _, e := range env {
+		args = append(args, "-e", e)
+	}
+	for _, e := range dcfg.Env {
+		args = append(args, "-e", e)
+	}
+	for _, e := range c.depends.Environ() {
+		args = append(args, "-e", e)
+	}
+	args = append(args, dcfg.Args...)
+	args = append(args, c.image)
+	args = append(args, c.config.Run...)
+
+	c.logger.Printf("Run> %s %s ...\n", c.docker, strings.Join(args, " "))
+
+	id, err := c.output(args...)
+	if err != nil {
+		return err
+	}
+
+	// Quit only stops the container if it is running, so a container started
+	// after Quit was called must be removed here
+	c.lock.Lock()
+	quitting := c.quitting
+	c.running = !quitting
+	c.lock.Unlock()
+
+	if quitting {
+		if _, err := c.output("rm", "-f", c.name); err != nil {
+			c.logger.Printf("Error removing container %s: %v\n", c.name, err)
+		}
+		return nil
+	}
+
+	c.logger.Printf("Started container %s (%.12s)\n", c.name, id)
+	c.publish(lifecycle.Event{Phase: lifecycle.RunStart})
+
+	return nil
+}
+
+// addr asks docker which host port the container port was published on.
+func (c *Container) addr() (net.Addr, error) {
+	out, err := c.output("port", c.name, c.port)
+	if err != nil {
+		return nil, err
+	}
+
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		hostport, err := netx.AddrToHostPort(strings.TrimSpace(s.Text()))
+		if err != nil {
+			continue
+		}
+
+		return netx.HostPortAddr(hostport), nil
+	}
+
+	return nil, fmt.Errorf("docker did not report a host port for container port %s of %s", c.port, c.name)
+}
+
+// streamLogs follows the container logs until the container exits.
+func (c *Container) streamLogs() error {
+	stdout, stderr := c.out.Stdout(output.Run), c.out.Stderr(output.Run)
+	defer stdout.Flush()
+	defer stderr.Flush()
+
+	cmd := c.command("logs", "-f", c.name)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	return cmd.Wait()
+}
+
+// SetDependencyAddr tells the container the address of the named target it
+// depends upon, which is passed to the build commands and the container in the
+// environment. A container already running keeps the address it was started
+// with.
+func (c *Container) SetDependencyAddr(name string, addr net.Addr) {
+	changed := c.depends.Set(name, addr)
+
+	c.lock.Lock()
+	started := c.started
+	c.lock.Unlock()
+
+	if started && changed {
+		c.logger.Printf("Dependency %s is now at %s, but container %s keeps the address it was started with.\n", name, addr, c.name)
+	}
+}
+
+// Start builds the image, runs the container, publishes the address the
+// container port is available on, and then follows the container logs.
+func (c *Container) Start() {
+	c.lock.Lock()
+	c.started = true
+	c.lock.Unlock()
+
+	c.done.Add(1)
+	go func() {
+		defer c.done.Done()
+
+		if err := c.build(); err != nil {
+			c.logger.Printf("Failed to build image for container %s: %v\n", c.name, err)
+			return
+		}
+
+		if c.isQuitting() {
+			return
+		}
+
+		if err := c.run(); err != nil {
+			c.logger.Printf("Failed to run container %s: %v\n", c.name, err)
+			return
+		}
+
+		if c.isQuitting() {
+			return
+		}
+
+		addr, err := c.addr()
+		if err != nil {
+			c.logger.Printf("Failed to find address of container %s: %v\n", c.name, err)
+		} else {
+			c.done.Add(1)
+			go func() {
+				defer c.done.Done()
+				c.addrs <- addr
+			}()
+		}
+
+		if err := c.streamLogs(); err != nil && !c.isQuitting() {
+			c.logger.Printf("Failed to follow logs of container %s: %v\n", c.name, err)
+		}
+
+		if c.isQuitting() {
+			c.publish(lifecycle.Event{Phase: lifecycle.Exit})
+			return
+		}
+
+		status, err := c.output("inspect", "-f", "{{.State.ExitCode}}", c.name)
+		if err != nil {
+			c.logger.Printf("Container %s quit unexpectedly.\n", c.name)
+			c.publish(lifecycle.Event{Phase: lifecycle.Exit, ExitCode: -1})
+			return
+		}
+
+		c.logger.Printf("Container %s quit unexpectedly with exit code %s.\n", c.name, status)
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			code = -1
+		}
+		c.publish(lifecycle.Event{Phase: lifecycle.Exit, ExitCode: code})
+	}()
+}
+
+func (c *Container) AddrListener() chan net.Addr {
+	return c.addrs
+}
+
+// Quit stops the build, if it is running, and then stops and removes the
+// container. Docker kills the container if it does not stop within the stop
+// timeout.
+func (c *Container) Quit() error {
+	c.lock.Lock()
+	c.quitting = true
+	builder := c.builder
+	running := c.running
+	c.lock.Unlock()
+
+	var err error
+	if builder != nil {
+		builder.Stop()
+		if berr := builder.Wait(); errors.Is(berr, acmd.ErrStopTimeout) {
+			err = berr
+		}
+	}
+
+	if !running {
+		return err
+	}
+
+	timeout := c.config.StopTimeout
+	if timeout == 0 {
+		timeout = acmd.DefaultStopTimeout
+	}
+
+	args := []string{"stop", "-t", stopSeconds(timeout)}
+	if c.config.StopSignal != "" {
+		args = append(args, "--signal", c.config.StopSignal)
+	}
+	args = append(args, c.name)
+
+	if _, serr := c.output(args...); serr != nil {
+		c.logger.Printf("Error stopping container %s: %v\n", c.name, serr)
+		err = serr
+	}
+
+	if _, rerr := c.output("rm", "-f", c.name); rerr != nil {
+		c.logger.Printf("Error removing container %s: %v\n", c.name, rerr)
+	}
+
+	return err
+}
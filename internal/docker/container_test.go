@@ -0,0 +1,264 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zostay/dev-tools/internal/netx"
+	"github.com/zostay/dev-tools/internal/output"
+	"github.com/zostay/dev-tools/pkg/config"
+	"github.com/zostay/dev-tools/pkg/lifecycle"
+)
+
+// fakeDocker is a docker CLI that records each command it is given and acts
+// just enough like docker for a container to be built, run, and stopped.
+const fakeDocker = `#!/bin/sh
+echo "$*" >> calls
+case "$1" in
+build)
+	echo "LOG=$LOG" > build-env
+	echo "built image"
+	;;
+run)
+	if [ -f hold ]; then
+		touch running
+		while [ ! -f release ]; do sleep 0.05; done
+	fi
+	echo 0123456789abcdef
+	;;
+port) echo "0.0.0.0:32768" ;;
+logs)
+	echo "listening"
+	touch following
+	while [ ! -f stopped ]; do sleep 0.05; done
+	;;
+stop) touch stopped ;;
+inspect) echo 0 ;;
+esac
+`
+
+// setupFakeDocker writes the fake docker CLI to a new directory and returns
+// the directory.
+func setupFakeDocker(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	docker := filepath.Join(dir, "docker")
+	if err := ioutil.WriteFile(docker, []byte(fakeDocker), 0755); err != nil {
+		t.Fatalf("unable to write fake docker: %v", err)
+	}
+
+	return dir
+}
+
+// calls returns the commands given to the fake docker CLI.
+func calls(t *testing.T, dir string) []string {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	if err != nil {
+		t.Fatalf("unable to read fake docker calls: %v", err)
+	}
+
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// waitForFile waits for the fake docker CLI to create the file.
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %s", path)
+}
+
+func TestContainer(t *testing.T) {
+	dir := setupFakeDocker(t)
+
+	var out bytes.Buffer
+	mux := output.New(&out, &out)
+
+	cfg := &config.WebTarget{
+		Type:       config.DockerTarget,
+		Run:        []string{"serve", "--verbose"},
+		WorkingDir: dir,
+		Env:        map[string]string{"log": "debug"},
+		Docker: config.DockerRun{
+			Command: filepath.Join(dir, "docker"),
+			Name:    "zx-test-api",
+			Context: ".",
+			Ports:   []string{"127.0.0.1::8080"},
+			Env:     []string{"MODE=dev"},
+		},
+	}
+
+	var (
+		phases     []lifecycle.Phase
+		phasesLock sync.Mutex
+		feed       = lifecycle.NewFeed()
+	)
+	feed.Subscribe(func(e lifecycle.Event) {
+		phasesLock.Lock()
+		defer phasesLock.Unlock()
+		phases = append(phases, e.Phase)
+	})
+
+	done := new(sync.WaitGroup)
+	c, err := New(mux.Source("api"), feed, "api", cfg, done)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	c.SetDependencyAddr("auth", netx.HostPortAddr("127.0.0.1:9000"))
+	c.Start()
+
+	select {
+	case addr := <-c.AddrListener():
+		if got, want := addr.String(), "127.0.0.1:32768"; got != want {
+			t.Errorf("addr = %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the container address")
+	}
+
+	waitForFile(t, filepath.Join(dir, "following"))
+
+	if err := c.Quit(); err != nil {
+		t.Errorf("Quit() error: %v", err)
+	}
+	done.Wait()
+
+	want := []string{
+		"build -t zx-test-api .",
+		"rm -f zx-test-api",
+		"run -d --name zx-test-api -p 127.0.0.1::8080 -e LOG=debug -e MODE=dev -e ZX_TARGET_AUTH_URL=http://127.0.0.1:9000 -e ZX_TARGET_AUTH_HOST=127.0.0.1 -e ZX_TARGET_AUTH_PORT=9000 zx-test-api serve --verbose",
+		"port zx-test-api 8080",
+		"logs -f zx-test-api",
+		"stop -t 10 zx-test-api",
+		"rm -f zx-test-api",
+	}
+	if got := calls(t, dir); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("docker was called with:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	buildEnv, err := ioutil.ReadFile(filepath.Join(dir, "build-env"))
+	if err != nil {
+		t.Fatalf("unable to read build env: %v", err)
+	}
+
+	if got := strings.TrimSpace(string(buildEnv)); got != "LOG=debug" {
+		t.Errorf("docker build was run with %q, want LOG=debug", got)
+	}
+
+	wantPhases := []lifecycle.Phase{lifecycle.BuildStart, lifecycle.BuildOK, lifecycle.RunStart, lifecycle.Exit}
+	if fmt.Sprint(phases) != fmt.Sprint(wantPhases) {
+		t.Errorf("published phases %v, want %v", phases, wantPhases)
+	}
+
+	if !strings.Contains(out.String(), "listening") {
+		t.Errorf("output is missing the container logs:\n%s", out.String())
+	}
+
+	if strings.Contains(out.String(), "quit unexpectedly") {
+		t.Errorf("container was reported to quit unexpectedly:\n%s", out.String())
+	}
+}
+
+func TestContainerQuitWhileStarting(t *testing.T) {
+	dir := setupFakeDocker(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "hold"), nil, 0644); err != nil {
+		t.Fatalf("unable to write hold file: %v", err)
+	}
+
+	cfg := &config.WebTarget{
+		Type:       config.DockerTarget,
+		WorkingDir: dir,
+		Docker: config.DockerRun{
+			Command: filepath.Join(dir, "docker"),
+			Name:    "zx-test-api",
+			Image:   "api",
+			Port:    "8080",
+		},
+	}
+
+	mux := output.New(ioutil.Discard, ioutil.Discard)
+	done := new(sync.WaitGroup)
+	c, err := New(mux.Source("api"), nil, "api", cfg, done)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	c.Start()
+	waitForFile(t, filepath.Join(dir, "running"))
+
+	if err := c.Quit(); err != nil {
+		t.Errorf("Quit() error: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "release"), nil, 0644); err != nil {
+		t.Fatalf("unable to write release file: %v", err)
+	}
+	done.Wait()
+
+	got := calls(t, dir)
+	if last := got[len(got)-1]; last != "rm -f zx-test-api" {
+		t.Errorf("container started while quitting was not removed, docker was called with:\n%s", strings.Join(got, "\n"))
+	}
+
+	for _, call := range got {
+		if strings.HasPrefix(call, "logs") || strings.HasPrefix(call, "port") {
+			t.Errorf("container started while quitting was used: docker %s", call)
+		}
+	}
+}
+
+func TestNewRequiresImage(t *testing.T) {
+	cfg := &config.WebTarget{
+		Type: config.DockerTarget,
+		Docker: config.DockerRun{
+			Ports: []string{"8080"},
+		},
+	}
+
+	mux := output.New(ioutil.Discard, ioutil.Discard)
+	if _, err := New(mux.Source("api"), nil, "api", cfg, new(sync.WaitGroup)); err == nil {
+		t.Error("New() with no image and no build succeeded, want error")
+	}
+
+	cfg.Docker.Image = "nginx"
+	if _, err := New(mux.Source("api"), nil, "api", cfg, new(sync.WaitGroup)); err != nil {
+		t.Errorf("New() with an image failed: %v", err)
+	}
+}
+
+func TestStopSeconds(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    string
+	}{
+		{10 * time.Second, "10"},
+		{500 * time.Millisecond, "1"},
+		{1500 * time.Millisecond, "2"},
+		{0, "0"},
+		{-time.Second, "-1"},
+	}
+
+	for _, tt := range tests {
+		if got := stopSeconds(tt.timeout); got != tt.want {
+			t.Errorf("stopSeconds(%v) = %q, want %q", tt.timeout, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package fswatch
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func setupFSNotifyRecursive(
+	e *emitter,
+	t *tree,
+	done *sync.WaitGroup,
+) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.Walk(func(path string, d fs.DirEntry) error {
+		if d.IsDir() || t.isTarget(path) {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	tlist := targetList(t.targets)
+
+	// accept adds the event to the batch unless ignored, watching any new
+	// directory, and returns false if watching has to stop
+	accept := func(batch *eventBatch, event fsnotify.Event) bool {
+		event.Name = filepath.Clean(event.Name)
+
+		fi, err := os.Stat(event.Name)
+		isDir := err == nil && fi.IsDir()
+		if !t.Accept(event.Name, isDir) {
+			return true
+		}
+
+		if isDir && t.recursive {
+			if event.Op == fsnotify.Create || event.Op == fsnotify.Rename {
+				if err := t.ig.load(event.Name); err != nil {
+					if !e.Error(err) {
+						return false
+					}
+				}
+
+				if err := watcher.Add(event.Name); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to add new directory %q to event string %s: %v\n", event.Name, tlist, err)
+					return false
+				}
+			}
+		}
+
+		batch.Add(Event(event))
+		return true
+	}
+
+	// refresh works out what to watch again and updates the watches to match
+	refresh := func() bool {
+		added, removed, err := t.Refresh()
+		if err != nil {
+			return e.Error(err)
+		}
+
+		for _, path := range removed {
+			// the directory may be gone already, which is fine
+			_ = watcher.Remove(path)
+		}
+
+		for _, path := range added {
+			if err := watcher.Add(path); err != nil {
+				if !e.Error(err) {
+					return false
+				}
+			}
+		}
+
+		tlist = targetList(t.targets)
+		return true
+	}
+
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					fmt.Fprintf(os.Stderr, "Unexpected clsoure of watcher event stream %s\n", tlist)
+					return
+				}
+
+				// take the events already waiting too, so that repeated
+				// events for a path are sent just once
+				batch := newEventBatch()
+				for ok {
+					if !accept(batch, event) {
+						return
+					}
+
+					select {
+					case event, ok = <-watcher.Events:
+					default:
+						ok = false
+					}
+				}
+
+				for _, event := range batch.Events() {
+					if !e.Event(event) {
+						return
+					}
+				}
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					fmt.Fprintf(os.Stderr, "Unexpected closure of watcher error stream %s\n", tlist)
+					return
+				}
+				if !e.Error(err) {
+					return
+				}
+
+			case <-e.Refreshes():
+				if !refresh() {
+					return
+				}
+
+			case <-e.quit:
+				return
+			}
+		}
+	}()
+
+	return e.Quit, nil
+}
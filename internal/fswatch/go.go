@@ -0,0 +1,216 @@
+package fswatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// goListPackage is the part of the output of go list -json used to work out
+// what to watch.
+type goListPackage struct {
+	Dir      string
+	Standard bool
+	Module   *struct {
+		Main  bool
+		GoMod string
+	}
+
+	GoFiles       []string
+	CgoFiles      []string
+	CFiles        []string
+	CXXFiles      []string
+	HFiles        []string
+	SFiles        []string
+	SysoFiles     []string
+	EmbedPatterns []string
+	EmbedFiles    []string
+}
+
+// goPackage is the set of files a Go package and the packages of the main
+// module it depends upon are built from. Only the directories of those packages
+// are watched, along with go.mod, go.sum, and any embedded files.
+type goPackage struct {
+	pkg string
+	dir string
+
+	dirs   map[string]struct{}
+	files  map[string]struct{}
+	embeds []string
+}
+
+// newGoPackage works out the files to watch for the named Go package, e.g.,
+// ./cmd/server, found from the given directory. An empty directory is the
+// current directory.
+func newGoPackage(pkg, dir string) (*goPackage, error) {
+	gp := goPackage{pkg: pkg, dir: dir}
+	if err := gp.Refresh(); err != nil {
+		return nil, err
+	}
+
+	return &gp, nil
+}
+
+// Refresh works out the files to watch again using go list.
+func (gp *goPackage) Refresh() error {
+	cmd := exec.Command("go", "list", "-e", "-deps", "-json", gp.pkg)
+	cmd.Dir = gp.dir
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("go list %s failed: %w: %s", gp.pkg, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var (
+		dirs   = make(map[string]struct{})
+		files  = make(map[string]struct{})
+		embeds = make([]string, 0)
+		dec    = json.NewDecoder(bytes.NewReader(out))
+	)
+
+	for {
+		var p goListPackage
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("unable to read go list %s: %w", gp.pkg, err)
+		}
+
+		if p.Standard || p.Module == nil || !p.Module.Main || p.Dir == "" {
+			continue
+		}
+
+		dir := relPath(p.Dir)
+		dirs[dir] = struct{}{}
+
+		if p.Module.GoMod != "" {
+			modDir := relPath(filepath.Dir(p.Module.GoMod))
+			dirs[modDir] = struct{}{}
+			files[filepath.Join(modDir, "go.mod")] = struct{}{}
+			files[filepath.Join(modDir, "go.sum")] = struct{}{}
+		}
+
+		lists := [][]string{
+			p.GoFiles, p.CgoFiles, p.CFiles, p.CXXFiles,
+			p.HFiles, p.SFiles, p.SysoFiles,
+		}
+		for _, list := range lists {
+			for _, f := range list {
+				files[filepath.Join(dir, f)] = struct{}{}
+			}
+		}
+
+		// embedded files may be in directories of their own
+		for _, f := range p.EmbedFiles {
+			path := filepath.Join(dir, f)
+			files[path] = struct{}{}
+			dirs[filepath.Dir(path)] = struct{}{}
+		}
+
+		// keep the patterns to match files added later, watching the
+		// directories they are added to
+		for _, pattern := range p.EmbedPatterns {
+			pattern = filepath.Join(dir, strings.TrimPrefix(pattern, "all:"))
+			embeds = append(embeds, pattern)
+
+			if base := patternDir(pattern); isDir(base) {
+				dirs[base] = struct{}{}
+			}
+		}
+	}
+
+	gp.dirs, gp.files, gp.embeds = dirs, files, embeds
+	return nil
+}
+
+// patternDir returns the longest leading directory of the pattern free of
+// glob characters. The pattern itself is returned if it has none, as it may
+// name a directory.
+func patternDir(pattern string) string {
+	i := strings.IndexAny(pattern, "*?[\\")
+	if i < 0 {
+		return pattern
+	}
+
+	return filepath.Dir(pattern[:i+1])
+}
+
+// isDir returns true if the path is a directory.
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// embedded returns true if the path matches one of the embed patterns or is
+// inside a directory that does, as embedding a directory embeds the files
+// within it.
+func (gp *goPackage) embedded(path string) bool {
+	for _, pattern := range gp.embeds {
+		for p := path; p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+			if ok, _ := filepath.Match(pattern, p); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Dirs returns the directories to watch, sorted.
+func (gp *goPackage) Dirs() []string {
+	dirs := make([]string, 0, len(gp.dirs))
+	for dir := range gp.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	return dirs
+}
+
+// Accept returns true if the path is one of the files the package is built
+// from, a file newly added that matches one of the embed patterns, or a Go file
+// newly added to one of the package directories.
+func (gp *goPackage) Accept(path string, isDir bool) bool {
+	if isDir {
+		return false
+	}
+
+	path = filepath.Clean(path)
+	if _, ok := gp.files[path]; ok {
+		return true
+	}
+
+	if gp.embedded(path) {
+		return true
+	}
+
+	if _, ok := gp.dirs[filepath.Dir(path)]; ok {
+		return filepath.Ext(path) == ".go" && !strings.HasSuffix(path, "_test.go")
+	}
+
+	return false
+}
+
+// relPath returns the path relative to the working directory, if it is inside
+// of it, so that event names look like they do for other watches.
+func relPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+
+	return rel
+}
@@ -0,0 +1,221 @@
+package fswatch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+// hashCacheFile is the name of the file in the state directory the content
+// hashes are kept in.
+const hashCacheFile = "hashes.json"
+
+// hashEntry is what is known about the content of a file.
+type hashEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash"`
+}
+
+// hashCache keeps the content hash of every watched file, so that files need
+// only be read again when they might have changed. It is shared by all the
+// watches of the process.
+type hashCache struct {
+	lock   sync.Mutex
+	path   string
+	hashes map[string]hashEntry
+	dirty  bool
+}
+
+var (
+	hashCachesLock sync.Mutex
+	hashCaches     = map[string]*hashCache{}
+)
+
+// openHashCache returns the hash cache kept in the project state directory,
+// loading it if this is the first time it has been asked for.
+func openHashCache() (*hashCache, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, hashCacheFile)
+
+	hashCachesLock.Lock()
+	defer hashCachesLock.Unlock()
+
+	if c, ok := hashCaches[path]; ok {
+		return c, nil
+	}
+
+	c := hashCache{
+		path:   path,
+		hashes: make(map[string]hashEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if len(data) > 0 {
+		// a broken cache is just started over
+		if err := json.Unmarshal(data, &c.hashes); err != nil {
+			c.hashes = make(map[string]hashEntry)
+		}
+	}
+
+	hashCaches[path] = &c
+	return &c, nil
+}
+
+// Save writes the cache back to the state directory if it has changed.
+func (c *hashCache) Save() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c.hashes)
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// Forget removes the file from the cache.
+func (c *hashCache) Forget(path string) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.hashes[key]; ok {
+		delete(c.hashes, key)
+		c.dirty = true
+	}
+}
+
+// Hash returns the hash of the content of the file. The file is only read if
+// its size or modification time differs from when it was last read.
+func (c *hashCache) Hash(path string) (string, error) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	c.lock.Lock()
+	old, known := c.hashes[key]
+	c.lock.Unlock()
+
+	if known && old.Size == fi.Size() && old.ModTime.Equal(fi.ModTime()) {
+		return old.Hash, nil
+	}
+
+	hash, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	c.lock.Lock()
+	c.hashes[key] = hashEntry{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Hash:    hash,
+	}
+	c.dirty = true
+	c.lock.Unlock()
+
+	return hash, nil
+}
+
+// hashFile returns the hash of the content of the file.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// contentChanged returns true if the event changes the content of the file
+// since the emitter last saw it. Events for directories and for files that
+// went away are always changes. A file replaced by another, as many editors do
+// when saving, is compared by content like any other.
+func (e *emitter) contentChanged(event Event) bool {
+	fi, err := os.Stat(event.Name)
+	if err != nil {
+		delete(e.seen, event.Name)
+		e.hashes.Forget(event.Name)
+		return true
+	}
+
+	if fi.IsDir() {
+		return true
+	}
+
+	hash, err := e.hashes.Hash(event.Name)
+	if err != nil {
+		return true
+	}
+
+	old, seen := e.seen[event.Name]
+	e.seen[event.Name] = hash
+	return !seen || old != hash
+}
+
+// seedHashes records the content of every watched file of the tree, so that
+// the first change to each is compared with what was there at the start.
+func (e *emitter) seedHashes(t *tree) error {
+	return t.Walk(func(path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+
+		if matches, err := e.matches(path); err != nil || !matches {
+			return err
+		}
+
+		if hash, err := e.hashes.Hash(path); err == nil {
+			e.seen[path] = hash
+		}
+		return nil
+	})
+}
@@ -0,0 +1,173 @@
+package fswatch
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+// ignoreFileNames are the names of the files listing paths to ignore when
+// ignore files are turned on.
+var ignoreFileNames = []string{".gitignore", ".zxignore"}
+
+// ignoreRule is a single pattern read from an ignore file.
+type ignoreRule struct {
+	base    string // the directory of the ignore file
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// ignorer decides which paths are to be left unwatched.
+type ignorer struct {
+	excludes    []string
+	ignoreFiles bool
+	rules       []ignoreRule
+	loaded      map[string]struct{}
+}
+
+// newIgnorer returns an ignorer for the given exclude patterns. If ignoreFiles
+// is true, the ignore files in the working directory are read right away.
+// Those in other directories are read by calling load as they are found.
+func newIgnorer(excludes []string, ignoreFiles bool) (*ignorer, error) {
+	ig := ignorer{
+		excludes:    excludes,
+		ignoreFiles: ignoreFiles,
+		loaded:      make(map[string]struct{}),
+	}
+
+	if err := ig.load("."); err != nil {
+		return nil, err
+	}
+
+	return &ig, nil
+}
+
+// load reads the ignore files in the given directory, if any, unless they have
+// been read already.
+func (ig *ignorer) load(dir string) error {
+	if !ig.ignoreFiles {
+		return nil
+	}
+
+	dir = filepath.Clean(dir)
+	if _, loaded := ig.loaded[dir]; loaded {
+		return nil
+	}
+	ig.loaded[dir] = struct{}{}
+
+	for _, name := range ignoreFileNames {
+		if err := ig.loadFile(dir, filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadFile reads the rules from a single ignore file. It is not an error for
+// the file to be missing.
+func (ig *ignorer) loadFile(dir, path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	base := filepath.ToSlash(filepath.Clean(dir))
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		r := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+
+		// a pattern with a slash in it is relative to the ignore file, others
+		// match at any depth
+		if strings.Contains(line, "/") {
+			r.pattern = strings.TrimPrefix(line, "/")
+		} else {
+			r.pattern = "**/" + line
+		}
+
+		if r.pattern != "" {
+			ig.rules = append(ig.rules, r)
+		}
+	}
+
+	return s.Err()
+}
+
+// Ignored returns true if the path, or any directory containing it, is to be
+// ignored.
+func (ig *ignorer) Ignored(path string, isDir bool) bool {
+	path = filepath.ToSlash(filepath.Clean(path))
+
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		if ig.ignored(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+
+	return ig.ignored(path, isDir)
+}
+
+// ignored returns true if the path itself is to be ignored.
+func (ig *ignorer) ignored(path string, isDir bool) bool {
+	if path == "." || path == ".." || strings.HasSuffix(path, "/..") {
+		return false
+	}
+
+	for _, g := range ig.excludes {
+		if matches, _ := doublestar.Match(filepath.ToSlash(g), path); matches {
+			return true
+		}
+	}
+
+	if !ig.ignoreFiles {
+		return false
+	}
+
+	if isDir && filepath.Base(path) == ".git" {
+		return true
+	}
+
+	// the last rule to match decides
+	ignored := false
+	for _, r := range ig.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+
+		rel := path
+		if r.base != "." {
+			if !strings.HasPrefix(path, r.base+"/") {
+				continue
+			}
+			rel = path[len(r.base)+1:]
+		}
+
+		if matches, _ := doublestar.Match(r.pattern, rel); matches {
+			ignored = !r.negate
+		}
+	}
+
+	return ignored
+}
@@ -0,0 +1,147 @@
+package fswatch
+
+import (
+	"io/fs"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultPollInterval is how often to scan for changes when polling, unless
+// configured otherwise.
+const defaultPollInterval = time.Second
+
+// fileState is what polling compares to decide whether a file has changed.
+type fileState struct {
+	isDir   bool
+	modTime time.Time
+	size    int64
+	mode    fs.FileMode
+}
+
+// scanTree records the state of every file and directory of the tree that is
+// to be watched.
+func scanTree(t *tree) (map[string]fileState, error) {
+	files := make(map[string]fileState)
+	err := t.Walk(func(path string, d fs.DirEntry) error {
+		fi, err := d.Info()
+		if err != nil {
+			// the file went away while scanning, so just skip it
+			return nil
+		}
+
+		files[path] = fileState{
+			isDir:   fi.IsDir(),
+			modTime: fi.ModTime(),
+			size:    fi.Size(),
+			mode:    fi.Mode(),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// pollEvents compares two scans and returns the events describing the
+// differences, ordered by path.
+func pollEvents(before, after map[string]fileState) []Event {
+	events := make([]Event, 0)
+	for path, a := range after {
+		b, existed := before[path]
+		switch {
+		case !existed:
+			events = append(events, Event{Name: path, Op: fsnotify.Create})
+		case !a.isDir && (!a.modTime.Equal(b.modTime) || a.size != b.size):
+			events = append(events, Event{Name: path, Op: fsnotify.Write})
+		case a.mode != b.mode:
+			events = append(events, Event{Name: path, Op: fsnotify.Chmod})
+		}
+	}
+
+	for path := range before {
+		if _, exists := after[path]; !exists {
+			events = append(events, Event{Name: path, Op: fsnotify.Remove})
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Name < events[j].Name
+	})
+
+	return events
+}
+
+// setupPoll watches the targets by scanning them for changes at the given
+// interval. This works anywhere, but is slower to notice changes and more work
+// than fsnotify.
+func setupPoll(
+	e *emitter,
+	t *tree,
+	interval time.Duration,
+	done *sync.WaitGroup,
+) (func(), error) {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	files, err := scanTree(t)
+	if err != nil {
+		return nil, err
+	}
+
+	done.Add(1)
+	go func() {
+		defer done.Done()
+
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-tick.C:
+				after, err := scanTree(t)
+				if err != nil {
+					if !e.Error(err) {
+						return
+					}
+					continue
+				}
+
+				for _, event := range pollEvents(files, after) {
+					if !e.Event(event) {
+						return
+					}
+				}
+				files = after
+
+			case <-e.Refreshes():
+				if _, _, err := t.Refresh(); err != nil {
+					if !e.Error(err) {
+						return
+					}
+					continue
+				}
+
+				// what is watched changed, not the files, so start over
+				after, err := scanTree(t)
+				if err != nil {
+					if !e.Error(err) {
+						return
+					}
+					continue
+				}
+				files = after
+
+			case <-e.quit:
+				return
+			}
+		}
+	}()
+
+	return e.Quit, nil
+}
@@ -0,0 +1,122 @@
+package fswatch
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+)
+
+// tree describes the files and directories to watch.
+type tree struct {
+	// targets are the files and directories to watch
+	targets []string
+
+	// recursive is set to watch everything inside the directories of targets,
+	// rather than just the files directly in them
+	recursive bool
+
+	ig  *ignorer
+	pkg *goPackage // set when watching the files of a Go package
+}
+
+// Accept returns true if changes to the path are to be watched.
+func (t *tree) Accept(path string, isDir bool) bool {
+	if t.ig.Ignored(path, isDir) {
+		return false
+	}
+
+	if t.pkg != nil {
+		return t.pkg.Accept(path, isDir)
+	}
+
+	return true
+}
+
+// Refresh works out the targets again if they can change, as they do for a Go
+// package. It returns the targets added and removed.
+func (t *tree) Refresh() (added, removed []string, err error) {
+	if t.pkg == nil {
+		return nil, nil, nil
+	}
+
+	if err := t.pkg.Refresh(); err != nil {
+		return nil, nil, err
+	}
+
+	old := make(map[string]struct{}, len(t.targets))
+	for _, target := range t.targets {
+		old[target] = struct{}{}
+	}
+
+	t.targets = t.pkg.Dirs()
+	for _, target := range t.targets {
+		if _, ok := old[target]; ok {
+			delete(old, target)
+		} else {
+			added = append(added, target)
+		}
+	}
+
+	for target := range old {
+		removed = append(removed, target)
+	}
+
+	return added, removed, nil
+}
+
+// isTarget returns true if the path is one of the targets.
+func (t *tree) isTarget(path string) bool {
+	for _, target := range t.targets {
+		if path == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk calls the function for every target and for every file and directory
+// within them that is to be watched. Ignored directories are skipped entirely.
+// The ignore files of each directory are loaded as it is found.
+func (t *tree) Walk(fn func(path string, d fs.DirEntry) error) error {
+	for _, target := range t.targets {
+		if err := t.walkTarget(target, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkTarget walks a single target for Walk.
+func (t *tree) walkTarget(target string, fn func(path string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(target, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// anything but the target itself may go away while walking
+			if path != target && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if path != target {
+			if d.IsDir() && !t.recursive {
+				return filepath.SkipDir
+			}
+
+			if !t.Accept(path, d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if d.IsDir() {
+			if err := t.ig.load(path); err != nil {
+				return err
+			}
+		}
+
+		return fn(path, d)
+	})
+}
@@ -2,7 +2,6 @@ package fswatch
 
 import (
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -29,6 +28,15 @@ type Watcher interface {
 	ErrorsListener() chan error
 }
 
+// Refresher is implemented by a Watcher that can tell when what is watched
+// ought to be worked out again, such as after a build. Only watches of a Go
+// package change what they watch.
+type Refresher interface {
+	// RefreshListener returns a channel that receives a value whenever what
+	// is watched ought to be worked out again.
+	RefreshListener() chan struct{}
+}
+
 // TODO Does this really need a WaitGroup? Do we really care about when the FS
 // notifier has completely finished watching something? Should it be sent as
 // part of the quitter instead or something?
@@ -39,100 +47,314 @@ type Watcher interface {
 // watching for file system changes. If an error is returned, the watcher is not
 // setup and no events should be sent.
 //
+// The files are watched using fsnotify or by polling, depending on the backend
+// configured. By default, fsnotify is used unless it cannot be setup, in which
+// case polling is used instead.
+//
+// A Go package to watch is found from the given directory, which is usually the
+// working directory of the target. An empty directory is the current directory.
+//
 // If a sync.WaitGroup is given, then it will be notified when the watcher has
 // quit.
-func SetupWatcher(w Watcher, done *sync.WaitGroup, config *config.FileWatch) (func(), error) {
+func SetupWatcher(w Watcher, done *sync.WaitGroup, cfg *config.FileWatch, dir string) (func(), error) {
 	// use a throw-away waitgroup if none is given.
 	if done == nil {
 		done = new(sync.WaitGroup)
 	}
 
-	quitter, err := setupFSNotifyRecursive(
-		w,
-		config.Targets,
-		config.Filters,
-		done,
-	)
-
-	return quitter, err
-}
+	ig, err := newIgnorer(cfg.Excludes, cfg.IgnoreFiles)
+	if err != nil {
+		return nil, err
+	}
 
-func setupFSNotifyRecursive(
-	w Watcher,
-	targets []string,
-	patterns []string,
-	done *sync.WaitGroup,
-) (func(), error) {
-	watcher, err := fsnotify.NewWatcher()
+	ops, err := parseOps(cfg.Ops)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, t := range targets {
-		err := filepath.WalkDir(t, func(path string, d fs.DirEntry, err error) error {
-			if d.IsDir() || path == t {
-				if err := watcher.Add(path); err != nil {
-					return err
-				}
-			}
-			return nil
-		})
+	e := newEmitter(w, cfg.Targets, cfg.Filters, ops)
+
+	t := tree{
+		targets:   cfg.Targets,
+		recursive: true,
+		ig:        ig,
+	}
+
+	if cfg.GoPackage != "" {
+		if len(cfg.Targets) > 0 {
+			return nil, fmt.Errorf("web.targets.….watches.….targets may not be set along with web.targets.….watches.….go_package")
+		}
+
+		t.pkg, err = newGoPackage(cfg.GoPackage, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		t.targets = t.pkg.Dirs()
+		t.recursive = false
+	}
+
+	if cfg.ContentHash {
+		e.hashes, err = openHashCache()
 		if err != nil {
-			watcher.Close()
+			return nil, err
+		}
+		e.seen = make(map[string]string)
+
+		if err := e.seedHashes(&t); err != nil {
 			return nil, err
 		}
 	}
 
-	quit := make(chan struct{})
-	tlist := "[" + strings.Join(targets, ",") + "]"
-	done.Add(1)
-	go func() {
-		defer done.Done()
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					fmt.Fprintf(os.Stderr, "Unexpected clsoure of watcher event stream %s\n", tlist)
-					return
-				}
-
-				if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
-					if event.Op == fsnotify.Create || event.Op == fsnotify.Rename {
-						if err := watcher.Add(event.Name); err != nil {
-							fmt.Fprintf(os.Stderr, "Failed to add new directory %q to event string %s: %v\n", event.Name, tlist, err)
-							return
-						}
-					}
-				}
-
-				if len(patterns) > 0 {
-					for _, g := range patterns {
-						matches, err := doublestar.PathMatch(g, event.Name)
-						if err != nil {
-							w.ErrorsListener() <- err
-						}
-
-						if matches {
-							w.EventsListener() <- Event(event)
-						}
-					}
-				} else {
-					w.EventsListener() <- Event(event)
-				}
-
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					fmt.Fprintf(os.Stderr, "Unexpected closure of watcher error stream %s\n", tlist)
-					return
-				}
-				w.ErrorsListener() <- err
-
-			case <-quit:
-				watcher.Close()
-				return
+	switch cfg.Backend {
+	case "", config.WatchAuto:
+		quitter, err := setupFSNotifyRecursive(e, &t, done)
+		if err == nil {
+			return quitter, nil
+		}
+
+		fmt.Fprintf(os.Stderr, "Unable to watch %s with fsnotify, polling instead: %v\n", targetList(t.targets), err)
+		return setupPoll(e, &t, cfg.PollInterval, done)
+
+	case config.WatchFSNotify:
+		return setupFSNotifyRecursive(e, &t, done)
+
+	case config.WatchPoll:
+		return setupPoll(e, &t, cfg.PollInterval, done)
+
+	default:
+		return nil, fmt.Errorf("web.targets.….watches.….backend must be %q, %q, or %q, not %q",
+			config.WatchAuto, config.WatchFSNotify, config.WatchPoll, cfg.Backend)
+	}
+}
+
+// targetList formats the list of watched targets for messages.
+func targetList(targets []string) string {
+	return "[" + strings.Join(targets, ",") + "]"
+}
+
+// eventBatch collects events, merging the events for each path into one.
+type eventBatch struct {
+	events []Event
+	index  map[string]int
+}
+
+// newEventBatch returns an empty batch.
+func newEventBatch() *eventBatch {
+	return &eventBatch{
+		events: make([]Event, 0),
+		index:  make(map[string]int),
+	}
+}
+
+// Add adds the event to the batch. If the batch already has an event for the
+// path, the operations of the event are added to that one instead.
+func (b *eventBatch) Add(event Event) {
+	if i, seen := b.index[event.Name]; seen {
+		b.events[i].Op |= event.Op
+		return
+	}
+
+	b.index[event.Name] = len(b.events)
+	b.events = append(b.events, event)
+}
+
+// Events returns the events of the batch in the order each path was first
+// seen.
+func (b *eventBatch) Events() []Event {
+	return b.events
+}
+
+// defaultOps are the kinds of changes watched for unless configured otherwise.
+const defaultOps = fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename
+
+// opNames maps the names used to configure the kinds of changes to watch for to
+// the fsnotify operations.
+var opNames = map[string]fsnotify.Op{
+	"create": fsnotify.Create,
+	"write":  fsnotify.Write,
+	"remove": fsnotify.Remove,
+	"rename": fsnotify.Rename,
+	"chmod":  fsnotify.Chmod,
+}
+
+// parseOps returns the operations named. With no names, it returns defaultOps.
+func parseOps(names []string) (fsnotify.Op, error) {
+	if len(names) == 0 {
+		return defaultOps, nil
+	}
+
+	var ops fsnotify.Op
+	for _, name := range names {
+		op, ok := opNames[strings.ToLower(name)]
+		if !ok {
+			return 0, fmt.Errorf("web.targets.….watches.….ops must be create, write, remove, rename, or chmod, not %q", name)
+		}
+		ops |= op
+	}
+
+	return ops, nil
+}
+
+// emitter passes the events matching the filters on to a Watcher until it is
+// told to quit.
+type emitter struct {
+	w        Watcher
+	targets  []string
+	patterns []string
+	ops      fsnotify.Op
+	hashes   *hashCache
+	seen     map[string]string
+
+	quit     chan struct{}
+	quitOnce *sync.Once
+}
+
+// newEmitter returns an emitter passing events for the given operations and
+// matching the given patterns on to the watcher. With no patterns, every event
+// for the operations is passed on.
+func newEmitter(w Watcher, targets, patterns []string, ops fsnotify.Op) *emitter {
+	cleaned := make([]string, len(targets))
+	for i, t := range targets {
+		cleaned[i] = filepath.Clean(t)
+	}
+
+	return &emitter{
+		w:        w,
+		targets:  cleaned,
+		patterns: patterns,
+		ops:      ops,
+		quit:     make(chan struct{}),
+		quitOnce: new(sync.Once),
+	}
+}
+
+// Quit tells the emitter to stop passing on events and saves the content
+// hashes, if any. It is safe to call more than once.
+func (e *emitter) Quit() {
+	e.quitOnce.Do(func() {
+		close(e.quit)
+
+		if e.hashes != nil {
+			if err := e.hashes.Save(); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to save content hashes: %v\n", err)
+			}
+		}
+	})
+}
+
+// Event passes the event on to the watcher once if it is for one of the
+// operations watched for and matches any of the filters. Operations not watched
+// for are removed from the event. If content hashes are kept, the event is only
+// passed on if the content of the file changed. It returns false if the emitter
+// was told to quit.
+func (e *emitter) Event(event Event) bool {
+	event.Op &= e.ops
+	if event.Op == 0 {
+		return true
+	}
+
+	matches, err := e.matches(event.Name)
+	if err != nil {
+		return e.send(nil, err)
+	}
+
+	if !matches {
+		return true
+	}
+
+	if e.hashes != nil && !e.contentChanged(event) {
+		return true
+	}
+
+	return e.send(&event, nil)
+}
+
+// matches returns true if the path matches any of the filters, either relative
+// to the target it is in or as a whole.
+func (e *emitter) matches(path string) (bool, error) {
+	if len(e.patterns) == 0 {
+		return true, nil
+	}
+
+	rel := e.relative(path)
+	for _, g := range e.patterns {
+		if rel != "" {
+			matches, err := doublestar.PathMatch(g, rel)
+			if err != nil {
+				return false, err
+			}
+
+			if matches {
+				return true, nil
 			}
 		}
-	}()
 
-	return func() { quit <- struct{}{} }, nil
+		matches, err := doublestar.PathMatch(g, path)
+		if err != nil {
+			return false, err
+		}
+
+		if matches {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// relative returns the path relative to the target containing it or the empty
+// string if the path is not within any of the targets.
+func (e *emitter) relative(path string) string {
+	path = filepath.Clean(path)
+	for _, t := range e.targets {
+		if path == t {
+			return filepath.Base(path)
+		}
+
+		if t == "." && !filepath.IsAbs(path) {
+			return path
+		}
+
+		if strings.HasPrefix(path, t+string(filepath.Separator)) {
+			return path[len(t)+1:]
+		}
+	}
+
+	return ""
+}
+
+// Refreshes returns the channel telling when to work out what is watched
+// again. It is nil if the watcher never says.
+func (e *emitter) Refreshes() chan struct{} {
+	if r, ok := e.w.(Refresher); ok {
+		return r.RefreshListener()
+	}
+	return nil
+}
+
+// Error passes the error on to the watcher. It returns false if the emitter was
+// told to quit.
+func (e *emitter) Error(err error) bool {
+	return e.send(nil, err)
+}
+
+// send passes an event or error on to the watcher, giving up if asked to quit
+// while waiting for the watcher to take it.
+func (e *emitter) send(event *Event, err error) bool {
+	if event != nil {
+		select {
+		case e.w.EventsListener() <- *event:
+			return true
+		case <-e.quit:
+			return false
+		}
+	}
+
+	select {
+	case e.w.ErrorsListener() <- err:
+		return true
+	case <-e.quit:
+		return false
+	}
 }
@@ -9,8 +9,26 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 )
 
+// shutdownTimeout is how long to wait for open connections to finish when
+// shutting down a server.
+const shutdownTimeout = 5 * time.Second
+
+// shutdown gracefully shuts down the server, closing it outright if open
+// connections do not finish in time.
+func shutdown(s *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := s.Shutdown(ctx)
+	if err != nil {
+		s.Close()
+	}
+	return err
+}
+
 type proxyURL struct {
 	to    *url.URL
 	proxy *httputil.ReverseProxy
@@ -63,11 +81,10 @@ func (f *Frontend) AddrListener() chan net.Addr {
 func (f *Frontend) Start() {
 }
 
-func (f *Frontend) Quit() {
-	err := f.s.Shutdown(context.TODO())
-	if err != nil {
-		f.logger.Printf("Error shutting down gohttp: %v\n", err)
-	}
+// Quit shuts down the server, giving open connections a few seconds to
+// finish.
+func (f *Frontend) Quit() error {
+	return shutdown(f.s)
 }
 
 type responseWriter struct {
@@ -0,0 +1,184 @@
+package gohttp
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+const (
+	defaultIndex        = "index.html"
+	defaultCacheControl = "no-cache"
+)
+
+// Static is a file server for serving a directory of static files during
+// development.
+type Static struct {
+	addrs        chan net.Addr
+	root         http.Dir
+	index        string
+	spa          bool
+	listing      bool
+	cacheControl string
+	s            *http.Server
+	done         *sync.WaitGroup
+	logger       *log.Logger
+}
+
+// NewStatic constructs a static file server for the given root directory
+// using the given options.
+func NewStatic(
+	done *sync.WaitGroup,
+	logger *log.Logger,
+	root string,
+	opts *config.StaticServe,
+) *Static {
+	index := opts.Index
+	if index == "" {
+		index = defaultIndex
+	}
+
+	cacheControl := opts.CacheControl
+	if cacheControl == "" {
+		cacheControl = defaultCacheControl
+	}
+
+	return &Static{
+		addrs:        make(chan net.Addr),
+		root:         http.Dir(root),
+		index:        index,
+		spa:          opts.SPA,
+		listing:      opts.Listing,
+		cacheControl: cacheControl,
+		s:            new(http.Server),
+		done:         done,
+		logger:       logger,
+	}
+}
+
+// Serve serves the files on the given listener and publishes the listener
+// address to the AddrListener() channel.
+func (s *Static) Serve(l net.Listener) error {
+	s.logger.Printf("Static file server for %s is listening on %s ...", string(s.root), l.Addr().String())
+
+	go func(a net.Addr) {
+		s.addrs <- a
+	}(l.Addr())
+
+	s.s.Handler = s.MakeHandler()
+	err := s.s.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s *Static) AddrListener() chan net.Addr {
+	return s.addrs
+}
+
+// Quit shuts down the server, giving open connections a few seconds to
+// finish.
+func (s *Static) Quit() error {
+	return shutdown(s.s)
+}
+
+// resolve decides how to answer a request for the given path. It returns the
+// path of the file to serve along with a flag that is true when the file
+// should be sent as is rather than handed to the file server. If the path
+// returned is empty, the request should be answered with a 404.
+func (s *Static) resolve(upath string) (string, bool) {
+	fi, err := s.stat(upath)
+	switch {
+	case err == nil && !fi.IsDir():
+		return upath, false
+
+	case err == nil:
+		index := path.Join(upath, s.index)
+		if fi, err := s.stat(index); err == nil && !fi.IsDir() {
+			return index, true
+		}
+
+		if s.listing {
+			return upath, false
+		}
+
+		if s.spa {
+			return "/" + s.index, true
+		}
+
+		return "", false
+
+	case os.IsNotExist(err) && s.spa && path.Ext(upath) == "":
+		return "/" + s.index, true
+
+	default:
+		return "", false
+	}
+}
+
+// stat returns the file info for the given path below the root.
+func (s *Static) stat(upath string) (os.FileInfo, error) {
+	f, err := s.root.Open(upath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Stat()
+}
+
+// serveFile sends the contents of the given file below the root.
+func (s *Static) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := s.root.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+func (s *Static) MakeHandler() http.HandlerFunc {
+	files := http.FileServer(s.root)
+	return func(w http.ResponseWriter, r *http.Request) {
+		ww := &responseWriter{ResponseWriter: w}
+		ww.Header().Set("Cache-Control", s.cacheControl)
+
+		upath := path.Clean("/" + r.URL.Path)
+		name, asIs := s.resolve(upath)
+		switch {
+		case name == "":
+			http.NotFound(ww, r)
+
+		case asIs && name != "/"+s.index && !strings.HasSuffix(r.URL.Path, "/"):
+			// a directory with an index, so make relative links work
+			http.Redirect(ww, r, path.Base(upath)+"/", http.StatusMovedPermanently)
+
+		case asIs:
+			s.serveFile(ww, r, name)
+
+		default:
+			files.ServeHTTP(ww, r)
+		}
+
+		if ww.wroteHeader {
+			s.logger.Printf("%s %s [%d] (%d bytes) -> %s", r.Method, r.URL.String(), ww.status, ww.bytesWritten, name)
+		} else {
+			s.logger.Printf("%s %s [200] (%d bytes) -> %s", r.Method, r.URL.String(), ww.bytesWritten, name)
+		}
+	}
+}
@@ -32,3 +32,27 @@ func AddrToURL(hostport string) (*url.URL, error) {
 
 	return url.Parse(urlText)
 }
+
+// HostPortAddr is a net.Addr for a TCP address given as a host:port string.
+type HostPortAddr string
+
+func (a HostPortAddr) Network() string {
+	return "tcp"
+}
+
+func (a HostPortAddr) String() string {
+	return string(a)
+}
+
+// FreePort asks the operating system for a TCP port that is not currently in
+// use and returns it. The port is released before returning, so there is a
+// small chance something else grabs it before the caller can use it.
+func FreePort() (int, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,273 @@
+package output
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LogFile is a log file that is rotated when it grows too large. The file
+// being written is always at the same path. When rotated, it is renamed with
+// a .1 suffix, the file that was .1 becomes .2, and so on, up to the number of
+// files to keep.
+type LogFile struct {
+	lock sync.Mutex
+
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	f    *os.File
+	size int64
+}
+
+// OpenLogFile opens the log file at the path for appending, creating it if
+// need be. It is rotated once it would grow past maxSize bytes, keeping
+// maxFiles rotated files.
+func OpenLogFile(path string, maxSize int64, maxFiles int) (*LogFile, error) {
+	l := LogFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// open opens the file at the path for appending.
+func (l *LogFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f, l.size = f, fi.Size()
+	return nil
+}
+
+// Write writes to the log file, rotating it first if the write would make it
+// too large.
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	if l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate moves each rotated file along by one, dropping the oldest, and starts
+// a new file.
+func (l *LogFile) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	l.f = nil
+
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		err := os.Rename(rotatedPath(l.path, i), rotatedPath(l.path, i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	if l.maxFiles > 0 {
+		if err := os.Rename(l.path, rotatedPath(l.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+// Close closes the log file. Writes after closing fail.
+func (l *LogFile) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// rotatedPath returns the path of the nth rotated file.
+func rotatedPath(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+// RotatedLogFiles returns the paths of the rotated files of the log file at
+// the path that exist, oldest first.
+func RotatedLogFiles(path string) []string {
+	var paths []string
+	for n := 1; ; n++ {
+		rpath := rotatedPath(path, n)
+		if _, err := os.Stat(rpath); err != nil {
+			break
+		}
+		paths = append([]string{rpath}, paths...)
+	}
+
+	return paths
+}
+
+// ReadLog calls the function with every record of the named source in the
+// log file at the path. Lines that cannot be parsed are skipped.
+func ReadLog(path, source string, fn func(Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, maxLogLine)
+	for s.Scan() {
+		r, err := ParseRecord(source, s.Text())
+		if err != nil {
+			continue
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return s.Err()
+}
+
+// maxLogLine is the longest line of a log file that can be read.
+const maxLogLine = 1024 * 1024
+
+// Tail reads the records added to a log file since it was last read. It keeps
+// reading across rotation of the file.
+type Tail struct {
+	path   string
+	source string
+
+	f       *os.File
+	partial string
+}
+
+// NewTail returns a Tail for the log file of the named source at the path.
+// The first read returns every record in the file, if any.
+func NewTail(path, source string) *Tail {
+	return &Tail{
+		path:   path,
+		source: source,
+	}
+}
+
+// Read returns the records written since the last read. A line only partly
+// written is held until it is complete.
+func (t *Tail) Read() ([]Record, error) {
+	var records []Record
+
+	if t.f == nil {
+		f, err := os.Open(t.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		t.f = f
+	}
+
+	// look before reading, so nothing written before rotation is missed
+	cur, statErr := os.Stat(t.path)
+
+	rs, err := t.readAll()
+	if err != nil {
+		return nil, err
+	}
+	records = append(records, rs...)
+
+	open, err := t.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if statErr == nil && os.SameFile(open, cur) {
+		return records, nil
+	}
+
+	// the file was rotated, so finish the old file and start on the new
+	rs, err = t.readAll()
+	if err != nil {
+		return nil, err
+	}
+	records = append(records, rs...)
+
+	t.f.Close()
+	t.f, t.partial = nil, ""
+
+	rs, err = t.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(records, rs...), nil
+}
+
+// readAll reads the rest of the open file and parses every complete line.
+func (t *Tail) readAll() ([]Record, error) {
+	buf, err := io.ReadAll(t.f)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(t.partial+string(buf), "\n")
+	t.partial = lines[len(lines)-1]
+
+	var records []Record
+	for _, line := range lines[:len(lines)-1] {
+		r, err := ParseRecord(t.source, line)
+		if err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+// Close closes the file being read.
+func (t *Tail) Close() error {
+	if t.f == nil {
+		return nil
+	}
+
+	err := t.f.Close()
+	t.f = nil
+	return err
+}
@@ -0,0 +1,364 @@
+// Package output multiplexes the output of many commands onto standard output
+// and standard error, prefixing every line with where it came from.
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// These are the kinds of output a source may have.
+const (
+	// Build is the output of the commands building a target.
+	Build = "build"
+
+	// Run is the output of a running target.
+	Run = "run"
+
+	// Zx is the output of zx itself about a target.
+	Zx = "zx"
+)
+
+// kindWidth is the width of the column the kind of output is written in.
+const kindWidth = 5
+
+// timestampFormat is the format of timestamps put before each line.
+const timestampFormat = "15:04:05.000"
+
+// dateTimestampFormat is the format of timestamps put before lines that were
+// not written today.
+const dateTimestampFormat = "2006-01-02 15:04:05.000"
+
+// maxRecent is the most recent lines of each kind a source keeps.
+const maxRecent = 200
+
+// colors are the ANSI colors given to each source in turn.
+var colors = []int{36, 33, 32, 35, 34, 31, 96, 93, 92, 95, 94, 91}
+
+// Mux writes the output of many sources to the same standard output and
+// standard error. Each line written is prefixed with the name of the source
+// and the kind of output. Lines are never mixed together, though lines from
+// different sources may be.
+type Mux struct {
+	lock sync.Mutex
+
+	stdout io.Writer
+	stderr io.Writer
+
+	color      bool
+	autoColor  bool
+	timestamps bool
+	capture    func(Record)
+
+	width   int
+	sources map[string]*Source
+}
+
+// New returns a Mux writing to the given standard output and standard error.
+// Color is turned on if standard output is a terminal, unless NO_COLOR is set.
+func New(stdout, stderr io.Writer) *Mux {
+	return &Mux{
+		stdout:    stdout,
+		stderr:    stderr,
+		color:     autoColor(stdout),
+		autoColor: true,
+		sources:   make(map[string]*Source),
+	}
+}
+
+// autoColor returns true if output written to the standard output should be
+// colored when color has not been turned on or off.
+func autoColor(stdout io.Writer) bool {
+	return isTerminal(stdout) && os.Getenv("NO_COLOR") == ""
+}
+
+// isTerminal returns true if the writer is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// SetOutput changes where standard output and standard error are written.
+// Unless color has been turned on or off with SetColor, whether to color the
+// output is worked out again from the new standard output as for New.
+func (m *Mux) SetOutput(stdout, stderr io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stdout, m.stderr = stdout, stderr
+	if m.autoColor {
+		m.color = autoColor(stdout)
+	}
+}
+
+// SetColor turns color on or off, whatever the output is written to.
+func (m *Mux) SetColor(color bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.color = color
+	m.autoColor = false
+}
+
+// SetTimestamps turns on or off writing the time before each line.
+func (m *Mux) SetTimestamps(timestamps bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.timestamps = timestamps
+}
+
+// Capture sends every record to the function instead of writing it out, such
+// as to show it in a dashboard. The function is called while writing, so it
+// must not block. Passing nil goes back to writing records out.
+func (m *Mux) Capture(fn func(Record)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.capture = fn
+}
+
+// Source returns the source with the given name, creating it the first time.
+// Each source is given a color of its own.
+func (m *Mux) Source(name string) *Source {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if s, ok := m.sources[name]; ok {
+		return s
+	}
+
+	s := Source{
+		mux:     m,
+		name:    name,
+		color:   colors[len(m.sources)%len(colors)],
+		writers: make(map[string]*Writer),
+		recent:  make(map[string][]Record),
+	}
+
+	m.sources[name] = &s
+	if len(name) > m.width {
+		m.width = len(name)
+	}
+
+	return &s
+}
+
+// WriteRecord writes the record as a line with its prefix to standard output
+// or standard error. The time of the record is used for the timestamp.
+func (m *Mux) WriteRecord(r Record) error {
+	return m.writeRecord(m.Source(r.Source), r)
+}
+
+// writeRecord writes the record of the source with its prefix.
+func (m *Mux) writeRecord(s *Source, r Record) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.capture != nil {
+		m.capture(r)
+		return nil
+	}
+
+	out := m.stdout
+	if r.Stderr {
+		out = m.stderr
+	}
+
+	prefix := new(strings.Builder)
+	if m.timestamps {
+		prefix.WriteString(stamp(r.Time))
+		prefix.WriteString(" ")
+	}
+
+	label := fmt.Sprintf("%-*s %-*s |", m.width, s.name, kindWidth, r.Kind)
+	if m.color {
+		fmt.Fprintf(prefix, "\x1b[%dm%s\x1b[0m ", s.color, label)
+	} else {
+		prefix.WriteString(label)
+		prefix.WriteString(" ")
+	}
+
+	_, err := io.WriteString(out, prefix.String()+r.Text+"\n")
+	return err
+}
+
+// stamp formats the time put before a line. The date is included when it is
+// not today.
+func stamp(t time.Time) string {
+	t = t.Local()
+
+	y, m, d := t.Date()
+	ny, nm, nd := time.Now().Date()
+	if y != ny || m != nm || d != nd {
+		return t.Format(dateTimestampFormat)
+	}
+
+	return t.Format(timestampFormat)
+}
+
+// Source is a named producer of output, such as a target.
+type Source struct {
+	mux   *Mux
+	name  string
+	color int
+
+	lock    sync.Mutex
+	writers map[string]*Writer
+	sink    io.Writer
+	recent  map[string][]Record
+}
+
+// Recent returns up to the last n lines of the given kind written by the
+// source, oldest first. Only the last few hundred lines of each kind are kept.
+func (s *Source) Recent(kind string, n int) []Record {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rs := s.recent[kind]
+	if n < len(rs) {
+		rs = rs[len(rs)-n:]
+	}
+
+	return append([]Record(nil), rs...)
+}
+
+// SetLog sets a writer every line of the source is also written to, as
+// formatted by Record.String, such as a log file.
+func (s *Source) SetLog(sink io.Writer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sink = sink
+}
+
+// writeLine writes a single line of the given kind to the log of the source,
+// if any, and then to the mux.
+func (s *Source) writeLine(kind string, stderr bool, line []byte) error {
+	r := Record{
+		Time:   time.Now(),
+		Source: s.name,
+		Kind:   kind,
+		Stderr: stderr,
+		Text:   strings.TrimSuffix(string(line), "\n"),
+	}
+
+	s.lock.Lock()
+	sink := s.sink
+	rs := append(s.recent[kind], r)
+	if len(rs) > maxRecent {
+		rs = append([]Record(nil), rs[len(rs)-maxRecent:]...)
+	}
+	s.recent[kind] = rs
+	s.lock.Unlock()
+
+	if sink != nil {
+		// failing to keep the log must not stop the output
+		_, _ = io.WriteString(sink, r.String())
+	}
+
+	return s.mux.writeRecord(s, r)
+}
+
+// Name returns the name of the source.
+func (s *Source) Name() string {
+	return s.name
+}
+
+// Stdout returns the writer for the given kind of output to standard output.
+func (s *Source) Stdout(kind string) *Writer {
+	return s.writer(kind, false)
+}
+
+// Stderr returns the writer for the given kind of output to standard error.
+func (s *Source) Stderr(kind string) *Writer {
+	return s.writer(kind, true)
+}
+
+// Logger returns a logger for the messages of zx about the source, which are
+// written to standard error.
+func (s *Source) Logger() *log.Logger {
+	return log.New(s.Stderr(Zx), "", 0)
+}
+
+// writer returns the writer for the kind of output and stream, creating it the
+// first time.
+func (s *Source) writer(kind string, stderr bool) *Writer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := kind
+	if stderr {
+		key += "/stderr"
+	}
+
+	if w, ok := s.writers[key]; ok {
+		return w
+	}
+
+	w := Writer{
+		source: s,
+		kind:   kind,
+		stderr: stderr,
+	}
+
+	s.writers[key] = &w
+	return &w
+}
+
+// Writer writes output for a source a line at a time. A partial line is held
+// until the rest of it is written or Flush is called.
+type Writer struct {
+	source *Source
+	kind   string
+	stderr bool
+
+	lock sync.Mutex
+	buf  []byte
+}
+
+// Write writes every complete line given and holds on to the rest.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := w.buf[:i+1]
+		w.buf = w.buf[i+1:]
+		if err := w.source.writeLine(w.kind, w.stderr, line); err != nil {
+			return len(p), err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush writes any partial line being held as a line of its own.
+func (w *Writer) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	line := w.buf
+	w.buf = nil
+	return w.source.writeLine(w.kind, w.stderr, line)
+}
@@ -0,0 +1,23 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSetOutputColor(t *testing.T) {
+	var buf bytes.Buffer
+
+	m := New(&buf, &buf)
+	m.color = true
+	m.SetOutput(&buf, &buf)
+	if m.color {
+		t.Error("color is on after switching to output that is not a terminal")
+	}
+
+	m.SetColor(true)
+	m.SetOutput(&buf, &buf)
+	if !m.color {
+		t.Error("color turned on by SetColor was turned off by SetOutput")
+	}
+}
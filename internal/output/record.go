@@ -0,0 +1,59 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// recordTimeFormat is the format of the time of each record in a log file.
+const recordTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// Record is a single line of output from a source.
+type Record struct {
+	Time   time.Time // when the line was written
+	Source string    // the name of the source
+	Kind   string    // the kind of output, such as Build or Run
+	Stderr bool      // set if written to standard error
+	Text   string    // the line without the newline
+}
+
+// String formats the record as a line of a log file. The source is left out,
+// as each source has a log file of its own.
+func (r Record) String() string {
+	stream := "out"
+	if r.Stderr {
+		stream = "err"
+	}
+
+	return fmt.Sprintf("%s %s %s | %s\n", r.Time.Format(recordTimeFormat), r.Kind, stream, r.Text)
+}
+
+// ParseRecord parses a line of the log file of the named source, as written by
+// Record.String.
+func ParseRecord(source, line string) (Record, error) {
+	line = strings.TrimSuffix(line, "\n")
+
+	parts := strings.SplitN(line, " | ", 2)
+	if len(parts) < 2 {
+		return Record{}, fmt.Errorf("log line %q is missing the | separator", line)
+	}
+
+	fields := strings.Fields(parts[0])
+	if len(fields) != 3 {
+		return Record{}, fmt.Errorf("log line %q does not start with time, kind, and stream", line)
+	}
+
+	t, err := time.Parse(recordTimeFormat, fields[0])
+	if err != nil {
+		return Record{}, fmt.Errorf("log line %q has a bad time: %w", line, err)
+	}
+
+	return Record{
+		Time:   t,
+		Source: source,
+		Kind:   fields[1],
+		Stderr: fields[2] == "err",
+		Text:   parts[1],
+	}, nil
+}
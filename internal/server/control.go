@@ -0,0 +1,241 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/zostay/dev-tools/pkg/lifecycle"
+)
+
+// These are the states a worker reports in its Status.
+const (
+	// StateWaiting is the state of a worker that has not been started yet,
+	// such as while it waits for the servers it depends upon.
+	StateWaiting = "waiting"
+
+	// StateBuilding is the state of a worker running its build.
+	StateBuilding = "building"
+
+	// StateRunning is the state of a worker running its server.
+	StateRunning = "running"
+
+	// StateFailed is the state of a worker whose build failed or whose server
+	// quit unexpectedly, while it waits to try again.
+	StateFailed = "failed"
+
+	// StateCrashLoop is the state of a worker whose server kept quitting soon
+	// after starting and is left stopped until the next rebuild or restart.
+	StateCrashLoop = "crash-loop"
+
+	// StateIdle is the state of a worker that has nothing to run.
+	StateIdle = "idle"
+
+	// StateStopped is the state of a worker that was told to stop.
+	StateStopped = "stopped"
+
+	// StateQuitting is the state of a worker that is shutting down.
+	StateQuitting = "quitting"
+)
+
+var (
+	// ErrNotStarted is returned when controlling a worker that has not been
+	// started yet.
+	ErrNotStarted = errors.New("the server has not been started yet")
+
+	// ErrStopped is returned when asking a stopped worker to rebuild or
+	// restart.
+	ErrStopped = errors.New("the server is stopped")
+
+	// ErrQuitting is returned when controlling a worker that is shutting
+	// down.
+	ErrQuitting = errors.New("the server is shutting down")
+)
+
+// Status describes what a worker is doing.
+type Status struct {
+	// State is one of the State constants.
+	State string
+
+	// Paused is set when changes to watched files are being ignored.
+	Paused bool
+}
+
+// reply sends the error to the reply channel of the event, if it has one.
+func reply(e *event, err error) {
+	if e.reply != nil {
+		e.reply <- err
+	}
+}
+
+// control sends a control event to the worker and waits for the reply.
+func (w *Worker) control(s state) error {
+	w.lock.Lock()
+	started := w.started
+	w.lock.Unlock()
+
+	if !started {
+		return ErrNotStarted
+	}
+
+	reply := make(chan error, 1)
+	w.events <- event{
+		state: s,
+		reply: reply,
+	}
+
+	return <-reply
+}
+
+// Rebuild runs the build again and restarts the server, as if a watched file
+// had changed.
+func (w *Worker) Rebuild() error {
+	return w.control(stateRebuild)
+}
+
+// Restart restarts the server without building it again.
+func (w *Worker) Restart() error {
+	return w.control(stateRestart)
+}
+
+// Stop stops the build and the server, if running, and leaves them stopped
+// until Resume is called. Changes to watched files are ignored in the
+// meantime.
+func (w *Worker) Stop() error {
+	return w.control(stateStop)
+}
+
+// Resume builds and runs the server again after Stop.
+func (w *Worker) Resume() error {
+	return w.control(stateResume)
+}
+
+// PauseWatching ignores changes to the watched files until ResumeWatching is
+// called.
+func (w *Worker) PauseWatching() error {
+	return w.control(statePause)
+}
+
+// ResumeWatching goes back to acting on changes to the watched files after
+// PauseWatching. Changes made while paused are not acted upon.
+func (w *Worker) ResumeWatching() error {
+	return w.control(stateUnpause)
+}
+
+// Status returns what the worker is doing.
+func (w *Worker) Status() Status {
+	w.lock.Lock()
+	started := w.started
+	w.lock.Unlock()
+
+	if !started {
+		return Status{State: StateWaiting}
+	}
+
+	status := make(chan Status, 1)
+	w.events <- event{
+		state:  stateStatus,
+		status: status,
+	}
+
+	return <-status
+}
+
+// status works out the status of the worker.
+func (w *Worker) status() Status {
+	s := Status{Paused: w.paused}
+
+	switch {
+	case w.quitting:
+		s.State = StateQuitting
+	case w.halted:
+		s.State = StateStopped
+	case w.builder != nil:
+		s.State = StateBuilding
+	case w.daemon != nil:
+		s.State = StateRunning
+	case w.crashLoop:
+		s.State = StateCrashLoop
+	case w.failed:
+		s.State = StateFailed
+	default:
+		s.State = StateIdle
+	}
+
+	return s
+}
+
+// begin runs the build, if there is one, or else the server.
+func (w *Worker) begin() {
+	if len(w.config.Build) > 0 {
+		w.setupBuilder()
+	} else {
+		w.setupDaemon()
+	}
+}
+
+// halt stops everything the worker runs and forgets any pending changes.
+func (w *Worker) halt() {
+	if w.halted {
+		return
+	}
+
+	w.logger.Printf("Stopping ...\n")
+	w.publish(lifecycle.Event{Phase: lifecycle.Stopped})
+	w.halted = true
+	w.restarting = false
+	w.forgetChanges()
+
+	if w.runner != nil {
+		w.runner.Stop()
+		w.runner = nil
+	}
+	w.runs = nil
+	w.runThen = actionNone
+
+	w.cancelBuild()
+
+	if w.daemon != nil {
+		w.daemon.Stop()
+	}
+}
+
+// resume starts the worker again after halt.
+func (w *Worker) resume() {
+	if !w.halted {
+		return
+	}
+
+	w.logger.Printf("Resuming ...\n")
+	w.halted = false
+	w.failed = false
+
+	// the server may still be on its way down
+	if w.daemon != nil {
+		w.restarting = true
+		if len(w.config.Build) > 0 {
+			w.setupBuilder()
+		}
+		return
+	}
+
+	w.begin()
+}
+
+// pause ignores changes to watched files from now on and forgets any pending
+// changes.
+func (w *Worker) pause() {
+	w.paused = true
+	w.forgetChanges()
+}
+
+// forgetChanges drops the changes waiting to settle.
+func (w *Worker) forgetChanges() {
+	if w.debounce != nil {
+		w.debounce.Stop()
+		w.debounce = nil
+	}
+
+	w.changes = make(map[string]struct{})
+	w.changeAction = actionNone
+	w.changeRuns = nil
+	w.changed = nil
+}
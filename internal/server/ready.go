@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/zostay/dev-tools/internal/netx"
+	"github.com/zostay/dev-tools/pkg/backoff"
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+const (
+	defaultReadyStatus   = http.StatusOK
+	defaultReadyInterval = 500 * time.Millisecond
+	defaultReadyTimeout  = 30 * time.Second
+)
+
+// waitReady repeatedly runs the readiness probes against the given address
+// until they all pass, the probe times out, or the context is canceled. The
+// probe command is run with env, the environment the server was started with.
+// The wait between attempts is timed by the clock.
+func waitReady(
+	ctx context.Context,
+	clock backoff.Clock,
+	probe *config.ReadyProbe,
+	workingDir string,
+	env []string,
+	addr net.Addr,
+) error {
+	interval := probe.Interval
+	if interval <= 0 {
+		interval = defaultReadyInterval
+	}
+
+	timeout := probe.Timeout
+	if timeout <= 0 {
+		timeout = defaultReadyTimeout
+	}
+
+	hostport, err := netx.AddrToHostPort(addr.String())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		err = checkReady(ctx, probe, workingDir, env, hostport, interval)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-clock.After(interval):
+		case <-ctx.Done():
+			return fmt.Errorf("server at %s not ready: %w", hostport, err)
+		}
+	}
+}
+
+// checkReady runs every configured probe once.
+func checkReady(
+	ctx context.Context,
+	probe *config.ReadyProbe,
+	workingDir string,
+	env []string,
+	hostport string,
+	interval time.Duration,
+) error {
+	if probe.TCP {
+		d := net.Dialer{Timeout: interval}
+		c, err := d.DialContext(ctx, "tcp", hostport)
+		if err != nil {
+			return err
+		}
+		c.Close()
+	}
+
+	if probe.HTTP != "" {
+		status := probe.Status
+		if status == 0 {
+			status = defaultReadyStatus
+		}
+
+		u, err := netx.AddrToURL(hostport)
+		if err != nil {
+			return err
+		}
+		u.Path = probe.HTTP
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+
+		client := http.Client{Timeout: interval}
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		if res.StatusCode != status {
+			return fmt.Errorf("GET %s returned status %d, expected %d", u.String(), res.StatusCode, status)
+		}
+	}
+
+	if len(probe.Command) > 0 {
+		cmd := exec.CommandContext(ctx, probe.Command[0], probe.Command[1:]...)
+		cmd.Dir = workingDir
+		cmd.Env = append(append(os.Environ(), env...), "ZX_ADDR="+hostport)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("ready command failed: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zostay/dev-tools/internal/netx"
+	"github.com/zostay/dev-tools/pkg/backoff"
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+func TestCheckReadyCommandEnv(t *testing.T) {
+	probe := &config.ReadyProbe{
+		Command: []string{"sh", "-c", `test "$PORT" = 18080 && test "$ZX_ADDR" = localhost:18080`},
+	}
+
+	env := []string{"PORT=18080"}
+	if err := checkReady(context.Background(), probe, t.TempDir(), env, "localhost:18080", defaultReadyInterval); err != nil {
+		t.Errorf("checkReady() error: %v", err)
+	}
+
+	if err := checkReady(context.Background(), probe, t.TempDir(), nil, "localhost:18080", defaultReadyInterval); err == nil {
+		t.Error("checkReady() without the server environment succeeded, want error")
+	}
+}
+
+func TestWaitReadyClock(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	probe := &config.ReadyProbe{TCP: true, Interval: time.Hour}
+	clock := backoff.NewFakeClock(time.Unix(0, 0))
+
+	result := make(chan error, 1)
+	go func() {
+		result <- waitReady(context.Background(), clock, probe, t.TempDir(), nil, netx.HostPortAddr(addr))
+	}()
+
+	// the first attempt fails as nothing is listening, so start listening
+	// before letting the wait between attempts pass
+	clock.BlockUntil(1)
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("unable to listen again: %v", err)
+	}
+	defer l.Close()
+	clock.Advance(time.Hour)
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("waitReady() error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the server to be ready")
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zostay/dev-tools/internal/output"
+	"github.com/zostay/dev-tools/pkg/backoff"
+	"github.com/zostay/dev-tools/pkg/config"
+	"github.com/zostay/dev-tools/pkg/lifecycle"
+)
+
+// checkRestart returns an error if the restart policy of the target is not
+// valid.
+func checkRestart(cfg *config.RestartPolicy) error {
+	switch cfg.Restart {
+	case "", config.RestartAlways, config.RestartOnFailure, config.RestartNever:
+	default:
+		return fmt.Errorf("web.targets.….restart must be %q, %q, or %q, not %q",
+			config.RestartAlways, config.RestartOnFailure, config.RestartNever, cfg.Restart)
+	}
+
+	if cfg.RestartJitter < 0 || cfg.RestartJitter > 1 {
+		return fmt.Errorf("web.targets.….restart_jitter must be from 0 to 1, not %v", cfg.RestartJitter)
+	}
+
+	return nil
+}
+
+// buildRetryDelay is how long to wait before building again after a build
+// fails.
+const buildRetryDelay = 5 * time.Second
+
+// buildBackoff returns the backoff that works out the wait before building
+// again after a build fails.
+func buildBackoff(clock backoff.Clock) *backoff.Backoff {
+	return backoff.New(backoff.Policy{
+		Strategy: backoff.Constant{Wait: buildRetryDelay},
+		Clock:    clock,
+	})
+}
+
+// restartBackoff returns the backoff that counts the times in a row the server
+// quit soon after starting and works out the wait before each restart.
+func restartBackoff(cfg *config.RestartPolicy, clock backoff.Clock) *backoff.Backoff {
+	s := backoff.Exponential{
+		Initial: cfg.RestartDelay,
+		Max:     cfg.RestartMaxDelay,
+		Jitter:  cfg.RestartJitter,
+	}
+
+	if s.Initial <= 0 {
+		s.Initial = config.DefaultRestartDelay
+	}
+
+	if s.Max <= 0 {
+		s.Max = config.DefaultRestartMaxDelay
+	}
+
+	// the first start is not a restart, so allow one more attempt
+	maxAttempts := cfg.MaxRestarts + 1
+	switch {
+	case cfg.MaxRestarts == 0:
+		maxAttempts = config.DefaultMaxRestarts + 1
+	case cfg.MaxRestarts < 0:
+		maxAttempts = 0
+	}
+
+	return backoff.New(backoff.Policy{
+		Strategy:    s,
+		MaxAttempts: maxAttempts,
+		Clock:       clock,
+	})
+}
+
+// crashed decides what to do after the daemon quit on its own, restarting it
+// after a wait if the restart policy calls for it. A daemon that keeps quitting
+// soon after it starts is left stopped once it has been restarted too many
+// times in a row.
+func (w *Worker) crashed(err error) {
+	cfg := &w.config.RestartPolicy
+
+	minUptime := cfg.MinUptime
+	if minUptime <= 0 {
+		minUptime = config.DefaultMinUptime
+	}
+
+	if w.clock.Now().Sub(w.runStart) >= minUptime {
+		w.backoff.Reset()
+	}
+
+	switch {
+	case cfg.Restart == config.RestartNever:
+		w.logger.Printf("Leaving the server stopped, as the restart policy is %s.\n", cfg.Restart)
+		return
+
+	case cfg.Restart == config.RestartOnFailure && err == nil:
+		w.logger.Printf("Leaving the server stopped, as it quit without error.\n")
+		return
+	}
+
+	wait, ok := w.backoff.Fail(err)
+	if !ok {
+		w.giveUp()
+		return
+	}
+
+	w.logger.Printf("Restarting the server in %v ...\n", wait.Round(time.Millisecond))
+	w.after(wait, stateRestart)
+}
+
+// after sends the worker an event with the given state once the wait has
+// passed on its clock, unless the worker stops first.
+func (w *Worker) after(wait time.Duration, s state) {
+	w.done.Add(1)
+	go func() {
+		defer w.done.Done()
+		select {
+		case <-w.clock.After(wait):
+		case <-w.stopped:
+			return
+		}
+
+		select {
+		case w.events <- event{state: s}:
+		case <-w.stopped:
+		}
+	}()
+}
+
+// giveUp gives up on restarting the daemon, reporting the last of its
+// output to show why it keeps quitting.
+func (w *Worker) giveUp() {
+	lines := w.config.CrashLogLines
+	if lines <= 0 {
+		lines = config.DefaultCrashLogLines
+	}
+
+	recent := w.out.Recent(output.Run, lines)
+	texts := make([]string, len(recent))
+	for i, r := range recent {
+		texts[i] = r.Text
+	}
+
+	w.logger.Printf("The server quit %d times in a row soon after starting, so it is left stopped until the next rebuild or restart.\n", w.backoff.Failures())
+	if len(texts) > 0 {
+		w.logger.Printf("The last %d line(s) of its output were:\n", len(texts))
+		for _, text := range texts {
+			w.logger.Printf("  | %s\n", text)
+		}
+	}
+
+	w.crashLoop = true
+	w.publish(lifecycle.Event{Phase: lifecycle.CrashLoop, Output: texts})
+}
@@ -8,7 +8,6 @@ import (
 	"log"
 	"net"
 	"net/url"
-	"os"
 	"os/exec"
 	"regexp"
 	"sync"
@@ -28,6 +27,10 @@ type RunCmd struct {
 	logger    *log.Logger
 }
 
+// RunCommand creates a command for running a server daemon. The output of the
+// command is copied to the Stdout and Stderr of the command. If addrMatch is
+// set, the output is scanned for the address of the server. Otherwise, the
+// server address is fixedAddr.
 func RunCommand(
 	workingDir string,
 	cmdLine []string,
@@ -35,6 +38,7 @@ func RunCommand(
 	logger *log.Logger,
 	addrMatch *regexp.Regexp,
 	addrFmt config.AddrFmt,
+	fixedAddr net.Addr,
 ) (*RunCmd, error) {
 	c, err := acmd.Command(workingDir, cmdLine, done, logger)
 	if err != nil {
@@ -56,32 +60,29 @@ func RunCommand(
 		stde, we := io.Pipe()
 		cmd.Stderr = we
 
-		stdor := io.TeeReader(stdo, os.Stdout)
-		stder := io.TeeReader(stde, os.Stderr)
+		c.StopHandler = func(error) {
+			wo.Close()
+			we.Close()
+		}
+
+		stdor := io.TeeReader(stdo, c.StdoutWriter())
+		stder := io.TeeReader(stde, c.StderrWriter())
 
-		err = r.monitorForAddr(stdor, stder)
-		if err != nil {
-			return err
+		if r.AddrMatch == nil {
+			r.addr.Keep(fixedAddr, nil)
+			go r.postAddrMatcher(bufio.NewScanner(stdor))
+			go r.postAddrMatcher(bufio.NewScanner(stder))
+			return nil
 		}
 
+		r.monitorForAddr(stdor, stder)
+
 		return nil
 	}
 
 	return &r, nil
 }
 
-type workerAddr struct {
-	host string
-}
-
-func (wa *workerAddr) Network() string {
-	return "tcp"
-}
-
-func (wa *workerAddr) String() string {
-	return wa.host
-}
-
 // postAddrMatcherReader continues reading from the scanner after the match so
 // output continues to be logged.
 func (r *RunCmd) postAddrMatcher(s *bufio.Scanner) {
@@ -90,55 +91,65 @@ func (r *RunCmd) postAddrMatcher(s *bufio.Scanner) {
 	}
 }
 
-func (r *RunCmd) addrMatcher(s *bufio.Scanner) future.Actor {
+// addrMatcher reads from the scanner until the address is found.
+func (r *RunCmd) addrMatcher(s *bufio.Scanner) (net.Addr, error) {
 	m := r.AddrMatch
-	return func() (interface{}, error) {
-		defer func() {
-			go r.postAddrMatcher(s)
-		}()
-
-		// TODO might want to apply a contextual timeout to limit how
-		// long we wait for the address to show up.
-		looking := true
-		for s.Scan() {
-			if looking {
-				if gs := m.FindStringSubmatch(s.Text()); len(gs) == 2 {
-					urlText := gs[1]
-					if r.AddrFmt == config.AddrFmtHostPort {
-						hostport, err := netx.AddrToHostPort(urlText)
-						if err != nil {
-							r.logger.Printf("Error parsing host:port %q to make address: %v", urlText, err)
-							return nil, err
-						}
-
-						urlText = fmt.Sprintf("http://%s", hostport)
-					}
-
-					url, err := url.Parse(urlText)
-					if err != nil {
-						r.logger.Printf("Error parsing URL %q to make address: %v", urlText, err)
-						return nil, err
-					}
-
-					return &workerAddr{url.Host}, nil
+
+	// TODO might want to apply a contextual timeout to limit how
+	// long we wait for the address to show up.
+	for s.Scan() {
+		if gs := m.FindStringSubmatch(s.Text()); len(gs) == 2 {
+			urlText := gs[1]
+			if r.AddrFmt == config.AddrFmtHostPort {
+				hostport, err := netx.AddrToHostPort(urlText)
+				if err != nil {
+					r.logger.Printf("Error parsing host:port %q to make address: %v", urlText, err)
+					continue
 				}
+
+				urlText = fmt.Sprintf("http://%s", hostport)
 			}
+
+			url, err := url.Parse(urlText)
+			if err != nil {
+				r.logger.Printf("Error parsing URL %q to make address: %v", urlText, err)
+				continue
+			}
+
+			return netx.HostPortAddr(url.Host), nil
 		}
-		return nil, errors.New("address never found in server log output")
 	}
+
+	return nil, errors.New("address never found in server log output")
 }
 
-func (r *RunCmd) monitorForAddr(rs ...io.Reader) error {
+// monitorForAddr scans all the readers for the server address. The first
+// address found on any of them is kept.
+func (r *RunCmd) monitorForAddr(rs ...io.Reader) {
+	var (
+		found    = new(sync.Once)
+		scanning = new(sync.WaitGroup)
+	)
+
+	scanning.Add(len(rs))
 	for _, rd := range rs {
-		s := bufio.NewScanner(rd)
-		r.addr.When(
-			future.Start(func(s *bufio.Scanner) future.Actor {
-				return r.addrMatcher(s)
-			}(s)),
-		)
+		go func(s *bufio.Scanner) {
+			addr, err := r.addrMatcher(s)
+			if err == nil {
+				found.Do(func() { r.addr.Keep(addr, nil) })
+			}
+
+			scanning.Done()
+			r.postAddrMatcher(s)
+		}(bufio.NewScanner(rd))
 	}
 
-	return nil
+	go func() {
+		scanning.Wait()
+		found.Do(func() {
+			r.addr.Keep(nil, errors.New("address never found in server log output"))
+		})
+	}()
 }
 
 func (r *RunCmd) Addr() (net.Addr, error) {
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zostay/dev-tools/internal/fswatch"
+	"github.com/zostay/dev-tools/internal/output"
+	"github.com/zostay/dev-tools/internal/targetcmd"
+	"github.com/zostay/dev-tools/pkg/acmd"
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+// watchAction is what needs to be done to the worker after files change. The
+// actions are ordered so that each includes the ones before it.
+type watchAction int
+
+const (
+	actionNone watchAction = iota
+	actionRestart
+	actionRebuild
+)
+
+// parseWatchAction returns the action named in the config.
+func parseWatchAction(name string) (watchAction, error) {
+	switch name {
+	case "":
+		return actionNone, nil
+	case config.WatchRestart:
+		return actionRestart, nil
+	case config.WatchRebuild:
+		return actionRebuild, nil
+	default:
+		return actionNone, fmt.Errorf("unknown action %q", name)
+	}
+}
+
+// checkWatch makes sure the action of the watch is configured correctly.
+func checkWatch(cfg *config.FileWatch) error {
+	switch cfg.Action {
+	case "", config.WatchRebuild, config.WatchRestart:
+		if len(cfg.Command) > 0 || cfg.Then != "" {
+			return fmt.Errorf("web.targets.….watches.….command and web.targets.….watches.….then may only be set when the action is %q", config.WatchRun)
+		}
+
+	case config.WatchRun:
+		if len(cfg.Command) == 0 {
+			return fmt.Errorf("you must set the web.targets.….watches.….command in the config when the action is %q", config.WatchRun)
+		}
+
+		if _, err := parseWatchAction(cfg.Then); err != nil {
+			return fmt.Errorf("web.targets.….watches.….then: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("web.targets.….watches.….action must be %q, %q, or %q, not %q",
+			config.WatchRebuild, config.WatchRestart, config.WatchRun, cfg.Action)
+	}
+
+	return nil
+}
+
+// watchEvent is a change to a file reported by one of the watches of the
+// worker.
+type watchEvent struct {
+	name  string
+	watch *config.FileWatch
+}
+
+// watcher is the fswatch.Watcher for a single watch of the worker. It passes
+// changes on to the worker along with the watch that saw them.
+type watcher struct {
+	events  chan fswatch.Event
+	errors  chan error
+	refresh chan struct{}
+}
+
+func (w *watcher) EventsListener() chan fswatch.Event {
+	return w.events
+}
+
+func (w *watcher) ErrorsListener() chan error {
+	return w.errors
+}
+
+func (w *watcher) RefreshListener() chan struct{} {
+	return w.refresh
+}
+
+// Watcher returns the fswatch.Watcher to use to watch files according to the
+// given watch configuration.
+func (w *Worker) Watcher(cfg config.FileWatch) fswatch.Watcher {
+	fw := watcher{
+		events:  make(chan fswatch.Event),
+		errors:  make(chan error),
+		refresh: make(chan struct{}, 1),
+	}
+	w.watchers = append(w.watchers, &fw)
+
+	w.done.Add(1)
+	go func() {
+		defer w.done.Done()
+		for {
+			select {
+			case e := <-fw.events:
+				select {
+				case w.fsevents <- watchEvent{e.Name, &cfg}:
+				case <-w.stopped:
+					return
+				}
+
+			case err := <-fw.errors:
+				select {
+				case w.fserrors <- err:
+				case <-w.stopped:
+					return
+				}
+
+			case <-w.stopped:
+				return
+			}
+		}
+	}()
+
+	return &fw
+}
+
+// refreshWatches tells the watches to work out what they watch again, as the
+// files a build uses may have changed.
+func (w *Worker) refreshWatches() {
+	for _, fw := range w.watchers {
+		select {
+		case fw.refresh <- struct{}{}:
+		default:
+			// a refresh is waiting already
+		}
+	}
+}
+
+// change records a change to a file seen by the given watch. The work to be
+// done waits until no more changes have been seen for the debounce time. A
+// build in progress is canceled if the change calls for a rebuild as it is
+// already out of date.
+func (w *Worker) change(name string, cfg *config.FileWatch) {
+	if w.quitting || w.halted || w.paused {
+		return
+	}
+
+	w.changes[name] = struct{}{}
+
+	switch cfg.Action {
+	case config.WatchRun:
+		w.changeRuns = appendWatch(w.changeRuns, cfg)
+		then, _ := parseWatchAction(cfg.Then)
+		w.changeAction = maxAction(w.changeAction, then)
+
+	case config.WatchRestart:
+		w.changeAction = maxAction(w.changeAction, actionRestart)
+
+	default:
+		w.changeAction = actionRebuild
+		w.cancelBuild()
+	}
+
+	wait := w.config.Debounce
+	if wait <= 0 {
+		wait = defaultDebounce
+	}
+
+	if w.debounce != nil && !w.debounce.Stop() {
+		<-w.debounce.C
+	}
+	w.debounce = time.NewTimer(wait)
+}
+
+// settled handles the changes once the watched files have stopped changing,
+// logging the files changed since the last time.
+func (w *Worker) settled() {
+	if w.quitting || len(w.changes) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(w.changes))
+	for name := range w.changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	action, runs := w.changeAction, w.changeRuns
+	w.changes = make(map[string]struct{})
+	w.changeAction = actionNone
+	w.changeRuns = nil
+
+	listed := names
+	if len(listed) > maxLoggedChanges {
+		listed = listed[:maxLoggedChanges]
+	}
+
+	w.changed = names
+
+	w.logger.Printf("Detected changes to %d file(s):\n", len(names))
+	for _, name := range listed {
+		w.logger.Printf("  %s\n", name)
+	}
+	if len(names) > len(listed) {
+		w.logger.Printf("  ... and %d more\n", len(names)-len(listed))
+	}
+
+	if len(runs) == 0 && w.runner == nil {
+		w.act(action)
+		return
+	}
+
+	// the action waits for the commands as they may change what is built
+	for _, cfg := range runs {
+		w.runs = appendWatch(w.runs, cfg)
+	}
+	w.runThen = maxAction(w.runThen, action)
+
+	if w.runner == nil {
+		w.nextRun()
+	}
+}
+
+// act rebuilds or restarts the worker.
+func (w *Worker) act(action watchAction) {
+	// the changes set off this action or none at all
+	defer func() { w.changed = nil }()
+
+	switch action {
+	case actionRebuild:
+		w.logger.Printf("Rebuilding ...\n")
+		w.rebuild()
+
+	case actionRestart:
+		// the server restarts anyway once the build in progress is done
+		if w.builder != nil {
+			return
+		}
+
+		w.logger.Printf("Restarting ...\n")
+		w.restart()
+	}
+}
+
+// nextRun starts the next command waiting to be run. Once they have all run,
+// the action they call for is taken.
+func (w *Worker) nextRun() {
+	if len(w.runs) == 0 {
+		action := w.runThen
+		w.runThen = actionNone
+		w.act(action)
+		return
+	}
+
+	cfg := w.runs[0]
+	w.runs = w.runs[1:]
+
+	var err error
+	w.runner, err = acmd.Command(
+		w.config.WorkingDir,
+		cfg.Command,
+		w.done,
+		w.logger,
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := targetcmd.Configure(w.runner, w.out.Name(), w.config); err != nil {
+		w.logger.Printf("Unable to configure watch command: %v\n", err)
+		w.runner = nil
+		w.runs = nil
+		w.runThen = actionNone
+		return
+	}
+	w.runner.EnvHandler = w.depends.Environ
+	w.runner.Stdout = w.out.Stdout(output.Build)
+	w.runner.Stderr = w.out.Stderr(output.Build)
+	w.runner.Start()
+
+	w.done.Add(1)
+	go func(c *acmd.Cmd) {
+		defer w.done.Done()
+		err := c.Wait()
+		w.events <- event{
+			state:  stateRan,
+			runner: c,
+			err:    err,
+		}
+	}(w.runner)
+}
+
+// ran handles a watch command finishing. If it failed, the rest of the
+// commands and the action after them are skipped until the next change.
+func (w *Worker) ran(c *acmd.Cmd, err error) {
+	if c != w.runner {
+		return
+	}
+
+	w.runner = nil
+
+	if w.quitting {
+		return
+	}
+
+	if err != nil {
+		w.logger.Printf("Command %q failed: %v\n", c.String(), err)
+		w.runs = nil
+		w.runThen = actionNone
+		return
+	}
+
+	w.nextRun()
+}
+
+// takeChanged returns the changed files that set off the current rebuild or
+// restart, if any, and forgets them.
+func (w *Worker) takeChanged() []string {
+	changed := w.changed
+	w.changed = nil
+	return changed
+}
+
+// appendWatch adds the watch to the list unless it is already there.
+func appendWatch(cfgs []*config.FileWatch, cfg *config.FileWatch) []*config.FileWatch {
+	for _, c := range cfgs {
+		if c == cfg {
+			return cfgs
+		}
+	}
+	return append(cfgs, cfg)
+}
+
+// maxAction returns the action that does the most.
+func maxAction(a, b watchAction) watchAction {
+	if a > b {
+		return a
+	}
+	return b
+}
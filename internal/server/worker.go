@@ -1,16 +1,24 @@
 package server
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"regexp"
+	"strconv"
 	"sync"
 	"time"
 
-	"github.com/zostay/dev-tools/internal/fswatch"
+	"github.com/zostay/dev-tools/internal/discovery"
+	"github.com/zostay/dev-tools/internal/netx"
+	"github.com/zostay/dev-tools/internal/output"
+	"github.com/zostay/dev-tools/internal/targetcmd"
 	"github.com/zostay/dev-tools/pkg/acmd"
+	"github.com/zostay/dev-tools/pkg/backoff"
 	"github.com/zostay/dev-tools/pkg/config"
+	"github.com/zostay/dev-tools/pkg/lifecycle"
 )
 
 type state int
@@ -19,66 +27,163 @@ const (
 	stateStart state = iota + 1
 	stateRebuild
 	stateRestart
-	stateChange
 	stateKill
+	stateExited
+	stateBuilt
+	stateRan
+	stateStop
+	stateResume
+	statePause
+	stateUnpause
+	stateStatus
 )
 
+// defaultDebounce is how long to wait for the watched files to settle before
+// rebuilding, unless configured otherwise.
+const defaultDebounce = 250 * time.Millisecond
+
+// maxLoggedChanges is the most changed files listed when logging a rebuild.
+const maxLoggedChanges = 10
+
 type event struct {
 	state state
 
-	name string
+	builder *acmd.Cmd
+	runner  *acmd.Cmd
+	daemon  *RunCmd
+	err     error
+
+	reply  chan error
+	status chan Status
 }
 
 // Worker is a state machine that maintains two processes. One runs
 // periodically, the builder, and the other runs all the time, the daemon. Both
 // processes are optional. We start by running the builder. If it fails, we keep
 // running the builder until it succeeds. Once it succeeds we run the daemon.
-// If the daemon quits for any reason, we restart it. If a rebuild is ordered,
+// If the daemon quits on its own, its restart policy decides whether to restart
+// it. If a rebuild is ordered,
 // then we start by starting the builder. We keep running the builder until it
 // succeeds. When the builder succeeds, we trigger a restart of the daemon. This
 // continues until we are told to quit.
 type Worker struct {
 	logger *log.Logger
+	out    *output.Source
+	feed   *lifecycle.Feed
 
 	config *config.WebTarget
 	done   *sync.WaitGroup
 
 	addrs     chan net.Addr
 	addrMatch *regexp.Regexp
+	fixedAddr net.Addr
+	portEnv   []string
+
+	lock    *sync.Mutex
+	started bool
+	depends *discovery.Addrs
 
 	events chan event
 
-	fsevents chan fswatch.Event
+	fsevents chan watchEvent
 	fserrors chan error
-
-	paused   bool
-	quitting bool
-
-	builder *acmd.Cmd
-	daemon  *RunCmd
-
+	stopped  chan struct{}
+	stoponce *sync.Once
+
+	changes      map[string]struct{}
+	changeAction watchAction
+	changeRuns   []*config.FileWatch
+	debounce     *time.Timer
+	changed      []string
+
+	runner  *acmd.Cmd
+	runs    []*config.FileWatch
+	runThen watchAction
+
+	quitting   bool
+	restarting bool
+	halted     bool
+	paused     bool
+	failed     bool
+
+	builder    *acmd.Cmd
+	buildStart time.Time
+	daemon     *RunCmd
+	runStart   time.Time
+
+	clock        backoff.Clock
+	backoff      *backoff.Backoff
+	buildBackoff *backoff.Backoff
+	crashLoop    bool
+
+	watchers []*watcher
 	quitters []func()
 }
 
-// NewWorker builds a new worker that runs a builder and a daemon. It will
-// notify the given sync.WaitGroup when the build and daemon processes have
-// completely quit.
+// NewWorker builds a new worker that runs a builder and a daemon. The output
+// of the commands and the messages of the worker are written to the given
+// output source and what happens to the target, named for the source, is
+// published to the feed. The waits before retrying a failed build or
+// restarting the daemon are taken from the clock, which defaults to
+// backoff.System when nil. It will notify the given sync.WaitGroup when the
+// build and daemon processes have completely quit.
 func NewWorker(
-	logger *log.Logger,
+	out *output.Source,
+	feed *lifecycle.Feed,
 	config *config.WebTarget,
+	clock backoff.Clock,
 	done *sync.WaitGroup,
 ) (*Worker, error) {
-	if config.AddressMatch == "" {
-		return nil, errors.New("you must set the web.targets.….server_address_match in the config")
+	var (
+		addrMatch *regexp.Regexp
+		fixedAddr net.Addr
+		logger    = out.Logger()
+	)
+
+	if _, err := config.Environ(); err != nil {
+		return nil, err
 	}
 
-	addrMatch, err := regexp.Compile(config.AddressMatch)
+	port, portEnv, err := serverPort(logger, config)
 	if err != nil {
 		return nil, err
 	}
 
+	if _, err := acmd.ParseSignal(config.StopSignal); err != nil {
+		return nil, fmt.Errorf("web.targets.%s.stop_signal: %w", out.Name(), err)
+	}
+
+	for i := range config.Watches {
+		if err := checkWatch(&config.Watches[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkRestart(&config.RestartPolicy); err != nil {
+		return nil, err
+	}
+
+	if port != "" {
+		fixedAddr = netx.HostPortAddr(net.JoinHostPort("localhost", port))
+	}
+
+	if clock == nil {
+		clock = backoff.System
+	}
+
+	if config.AddressMatch != "" {
+		addrMatch, err = regexp.Compile(config.AddressMatch)
+		if err != nil {
+			return nil, err
+		}
+	} else if fixedAddr == nil {
+		return nil, errors.New("you must set the web.targets.….address_match or web.targets.….port in the config")
+	}
+
 	w := Worker{
 		logger: logger,
+		out:    out,
+		feed:   feed,
 
 		config: config,
 		done:   done,
@@ -87,11 +192,22 @@ func NewWorker(
 
 		addrs:     make(chan net.Addr),
 		addrMatch: addrMatch,
+		fixedAddr: fixedAddr,
+		portEnv:   portEnv,
+
+		lock:    new(sync.Mutex),
+		depends: discovery.NewAddrs(),
 
-		fsevents: make(chan fswatch.Event),
+		fsevents: make(chan watchEvent),
 		fserrors: make(chan error),
+		stopped:  make(chan struct{}),
+		stoponce: new(sync.Once),
 
-		paused: true,
+		changes: make(map[string]struct{}),
+
+		clock:        clock,
+		backoff:      restartBackoff(&config.RestartPolicy, clock),
+		buildBackoff: buildBackoff(clock),
 
 		quitters: make([]func(), 0),
 	}
@@ -99,6 +215,37 @@ func NewWorker(
 	return &w, nil
 }
 
+// serverPort returns the port the server is configured to listen on, picking
+// a free one if configured to do so, along with the environment needed to tell
+// the server about it. The port is empty if none is configured.
+func serverPort(logger *log.Logger, target *config.WebTarget) (string, []string, error) {
+	port := target.Port
+	if port == "" {
+		return "", nil, nil
+	}
+
+	if port == config.AutoPort {
+		p, err := netx.FreePort()
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to pick a port for the server: %w", err)
+		}
+
+		port = strconv.Itoa(p)
+		logger.Printf("Picked port %s for the server.\n", port)
+	}
+
+	if _, err := strconv.Atoi(port); err != nil {
+		return "", nil, fmt.Errorf("web.targets.….port must be a port number or %q, not %q", config.AutoPort, port)
+	}
+
+	portEnv := target.PortEnv
+	if portEnv == "" {
+		portEnv = config.DefaultPortEnv
+	}
+
+	return port, []string{portEnv + "=" + port}, nil
+}
+
 func (w *Worker) setupBuilder() {
 	if len(w.config.Build) == 0 {
 		return
@@ -115,22 +262,67 @@ func (w *Worker) setupBuilder() {
 		panic(err)
 	}
 
+	if err := targetcmd.Configure(w.builder, w.out.Name(), w.config); err != nil {
+		w.logger.Printf("Unable to configure build: %v\n", err)
+		w.builder = nil
+		return
+	}
+	w.builder.Env = append(w.builder.Env, w.portEnv...)
+	w.builder.EnvHandler = w.depends.Environ
+	w.builder.ExpandArgs = true
+	w.builder.Retry.Clock = w.clock
+	w.builder.Stdout = w.out.Stdout(output.Build)
+	w.builder.Stderr = w.out.Stderr(output.Build)
 	w.builder.Start()
+	w.buildStart = w.clock.Now()
+	w.publish(lifecycle.Event{Phase: lifecycle.BuildStart, Files: w.takeChanged()})
+	w.failed = false
 	w.done.Add(1)
-	go func() {
+	go func(b *acmd.Cmd) {
 		defer w.done.Done()
-		err := w.builder.Wait()
-		if err != nil {
-			<-time.After(5 * time.Second)
-			w.events <- event{
-				state: stateRebuild,
-			}
-		} else {
-			w.events <- event{
-				state: stateRestart,
-			}
+		err := b.Wait()
+		w.events <- event{
+			state:   stateBuilt,
+			builder: b,
+			err:     err,
 		}
-	}()
+	}(w.builder)
+}
+
+// built handles the builder finishing. If the build succeeded, the daemon is
+// restarted. If it failed, the build is tried again after a short wait.
+func (w *Worker) built(b *acmd.Cmd, err error) {
+	if b != w.builder {
+		return
+	}
+
+	w.builder = nil
+
+	if w.quitting {
+		return
+	}
+
+	took := w.clock.Now().Sub(w.buildStart)
+	if err == nil {
+		w.publish(lifecycle.Event{Phase: lifecycle.BuildOK, Duration: took})
+		w.backoff.Reset()
+		w.buildBackoff.Reset()
+		w.refreshWatches()
+		w.restart()
+		return
+	}
+
+	w.logger.Printf("build failed: %v", err)
+	w.publish(lifecycle.Event{
+		Phase:    lifecycle.BuildFail,
+		Duration: took,
+		ExitCode: acmd.ExitCode(err),
+		Err:      err,
+	})
+	w.failed = true
+
+	wait, _ := w.buildBackoff.Fail(err)
+	w.after(wait, stateRebuild)
 }
 
 func (w *Worker) setupDaemon() {
@@ -146,43 +338,105 @@ func (w *Worker) setupDaemon() {
 		w.logger,
 		w.addrMatch,
 		w.config.AddressFormat,
+		w.fixedAddr,
 	)
 	if err != nil {
 		panic(err)
 	}
 
+	if err := targetcmd.Configure(w.daemon.Cmd, w.out.Name(), w.config); err != nil {
+		w.logger.Printf("Unable to configure server: %v\n", err)
+		w.daemon = nil
+		return
+	}
+
+	w.daemon.Env = append(w.daemon.Env, w.portEnv...)
+	w.daemon.EnvHandler = w.depends.Environ
+	w.daemon.ExpandArgs = true
+	w.daemon.Retry.Clock = w.clock
+	w.daemon.Stdout = w.out.Stdout(output.Run)
+	w.daemon.Stderr = w.out.Stderr(output.Run)
+
+	// the readiness probe sees the same environment as the daemon
+	env := append(append([]string{}, w.daemon.Env...), w.depends.Environ()...)
+
+	// canceled when the daemon quits to stop waiting on readiness
+	ctx, cancel := context.WithCancel(context.Background())
+
 	w.daemon.Start()
+	w.runStart = w.clock.Now()
+	w.crashLoop = false
+	w.publish(lifecycle.Event{Phase: lifecycle.RunStart})
+	w.failed = false
 	w.done.Add(2)
 	go func(r *RunCmd) {
+		defer w.done.Done()
 		addr, err := r.Addr()
 		if err != nil {
 			w.logger.Printf("error reading server address: %v", err)
 			return
 		}
+
+		if w.config.Ready.Enabled() {
+			w.logger.Printf("Waiting for server at %s to be ready ...\n", addr)
+			err := waitReady(ctx, w.clock, &w.config.Ready, w.config.WorkingDir, env, addr)
+			if err != nil {
+				w.logger.Printf("Readiness probe failed: %v\n", err)
+				return
+			}
+		}
+
 		w.addrs <- addr
 	}(w.daemon)
 
 	go func(r *RunCmd) {
 		defer w.done.Done()
 		err := r.Wait()
-		if err != nil && err.Error() != "signal: hangup" {
-			w.logger.Printf("unexpected quit: %v", err)
-			<-time.After(5 * time.Second)
-		} else {
-			<-time.After(100 * time.Millisecond)
-		}
+		cancel()
 
 		w.events <- event{
-			state: stateRestart,
+			state:  stateExited,
+			daemon: r,
+			err:    err,
 		}
 	}(w.daemon)
 }
 
+// SetDependencyAddr tells the worker the address of the named target it
+// depends upon. If the worker has started and the address has changed, the
+// worker rebuilds and restarts so the commands see the new address.
+func (w *Worker) SetDependencyAddr(name string, addr net.Addr) {
+	changed := w.depends.Set(name, addr)
+
+	w.lock.Lock()
+	started := w.started
+	w.lock.Unlock()
+
+	if !started || !changed {
+		return
+	}
+
+	w.logger.Printf("Dependency %s is now at %s, rebuilding ...\n", name, addr)
+
+	w.done.Add(1)
+	go func() {
+		defer w.done.Done()
+		w.events <- event{
+			state: stateRebuild,
+		}
+	}()
+}
+
 func (w *Worker) Start() {
 	w.done.Add(1)
 	go func() {
 		defer w.done.Done()
 		for {
+			var settled <-chan time.Time
+			if w.debounce != nil {
+				settled = w.debounce.C
+			}
+
 			select {
 			case e := <-w.events:
 				if quit := w.handle(&e); quit {
@@ -190,14 +444,11 @@ func (w *Worker) Start() {
 				}
 
 			case e := <-w.fsevents:
-				w.done.Add(1)
-				go func() {
-					defer w.done.Done()
-					w.events <- event{
-						state: stateChange,
-						name:  e.Name,
-					}
-				}()
+				w.change(e.name, e.watch)
+
+			case <-settled:
+				w.debounce = nil
+				w.settled()
 
 			case err := <-w.fserrors:
 				w.logger.Printf("FSNotify Error: %v\n", err)
@@ -205,28 +456,79 @@ func (w *Worker) Start() {
 		}
 	}()
 
+	w.lock.Lock()
+	w.started = true
+	w.lock.Unlock()
+
 	w.events <- event{
 		state: stateStart,
 	}
 }
 
 func (w *Worker) handle(e *event) bool {
+	if w.quitting {
+		switch e.state {
+		case stateStart, stateRebuild, stateRestart, stateStop, stateResume, statePause, stateUnpause:
+			reply(e, ErrQuitting)
+			return false
+		}
+	}
+
+	if w.halted {
+		switch e.state {
+		case stateRebuild, stateRestart:
+			reply(e, ErrStopped)
+			return false
+		}
+	}
+
 	switch e.state {
 	case stateStart:
-		w.setupBuilder()
-		w.setupDaemon()
-
-	case stateChange:
-		w.change(e.name)
+		w.begin()
 
 	case stateRebuild:
 		w.rebuild()
+		reply(e, nil)
 
 	case stateRestart:
+		if e.reply != nil {
+			// asked for by hand, so give the server a fresh start
+			w.backoff.Reset()
+		}
 		w.restart()
+		reply(e, nil)
+
+	case stateStop:
+		w.halt()
+		reply(e, nil)
+
+	case stateResume:
+		w.backoff.Reset()
+		w.resume()
+		reply(e, nil)
+
+	case statePause:
+		w.pause()
+		reply(e, nil)
+
+	case stateUnpause:
+		w.paused = false
+		reply(e, nil)
+
+	case stateStatus:
+		e.status <- w.status()
 
 	case stateKill:
-		w.kill()
+		w.kill(e.reply)
+
+	case stateExited:
+		w.exited(e.daemon, e.err)
+
+	case stateBuilt:
+		w.built(e.builder, e.err)
+
+	case stateRan:
+		w.ran(e.runner, e.err)
 
 	default:
 		panic("unknown worker state")
@@ -234,58 +536,163 @@ func (w *Worker) handle(e *event) bool {
 	return false
 }
 
+// rebuild runs the builder, canceling any build already in progress, and then
+// restarts the daemon. With no builder, it just restarts the daemon.
 func (w *Worker) rebuild() {
+	if len(w.config.Build) == 0 {
+		w.restart()
+		return
+	}
+
+	w.cancelBuild()
 	w.setupBuilder()
 }
 
+// cancelBuild stops the build in progress, if any. The result of the canceled
+// build is ignored.
+func (w *Worker) cancelBuild() {
+	if w.builder == nil {
+		return
+	}
+
+	w.logger.Printf("Canceling build in progress ...\n")
+	w.builder.Stop()
+	w.builder = nil
+}
+
+// restart stops the daemon, if it is running, and then starts it again.
 func (w *Worker) restart() {
+	if w.daemon != nil {
+		w.restarting = true
+		w.publish(lifecycle.Event{Phase: lifecycle.Restart, Files: w.takeChanged()})
+		w.daemon.Stop()
+		return
+	}
+
 	w.setupDaemon()
 }
 
-func (w *Worker) change(name string) {
-	if w.paused {
+// exited handles the daemon quitting. If we stopped it to restart it, it is
+// started again right away. If it quit on its own, the restart policy decides
+// whether and when it is started again.
+func (w *Worker) exited(r *RunCmd, err error) {
+	if r != w.daemon {
 		return
 	}
 
-	w.paused = true
-	w.logger.Printf("FSNotify detected change: %s\n", name)
+	w.daemon = nil
+	w.publish(lifecycle.Event{Phase: lifecycle.Exit, ExitCode: acmd.ExitCode(err), Err: err})
+
+	if w.quitting || w.halted {
+		return
+	}
+
+	if w.restarting {
+		w.restarting = false
+		w.setupDaemon()
+		return
+	}
+
+	if err != nil {
+		w.logger.Printf("unexpected quit: %v", err)
+	} else {
+		w.logger.Printf("unexpected quit")
+	}
+	w.failed = true
+
+	w.crashed(err)
+}
+
+// kill stops watching for changes and stops the builder and daemon. The reply
+// channel receives ErrStopTimeout if either had to be killed once both have
+// quit.
+func (w *Worker) kill(reply chan error) {
+	if w.quitting {
+		reply <- nil
+		return
+	}
+
+	w.quitting = true
+
+	if w.debounce != nil {
+		w.debounce.Stop()
+		w.debounce = nil
+	}
+
+	w.stopWatching()
+
+	cmds := make([]*acmd.Cmd, 0, 3)
+	if w.runner != nil {
+		w.runner.Stop()
+		cmds = append(cmds, w.runner)
+	}
+
+	if w.builder != nil {
+		w.builder.Stop()
+		cmds = append(cmds, w.builder)
+	}
+
+	if w.daemon != nil {
+		w.daemon.Stop()
+		cmds = append(cmds, w.daemon.Cmd)
+	}
 
 	w.done.Add(1)
 	go func() {
 		defer w.done.Done()
-		w.events <- event{
-			state: stateRebuild,
+
+		var err error
+		for _, c := range cmds {
+			if cerr := c.Wait(); errors.Is(cerr, acmd.ErrStopTimeout) {
+				err = cerr
+			}
 		}
+
+		reply <- err
 	}()
 }
 
-func (w *Worker) kill() {
-	w.quitting = true
-
-	w.builder.Stop()
-	w.daemon.Stop()
+// publish sends the event to the feed on behalf of the target.
+func (w *Worker) publish(e lifecycle.Event) {
+	e.Target = w.out.Name()
+	w.feed.Publish(e)
 }
 
 func (w *Worker) AddrListener() chan net.Addr {
 	return w.addrs
 }
 
-func (w *Worker) EventsListener() chan fswatch.Event {
-	return w.fsevents
-}
-
-func (w *Worker) ErrorsListener() chan error {
-	return w.fserrors
+// stopWatching stops watching for changes to files.
+func (w *Worker) stopWatching() {
+	w.stoponce.Do(func() {
+		close(w.stopped)
+		for _, q := range w.quitters {
+			q()
+		}
+	})
 }
 
 func (w *Worker) RegisterQuitter(q func()) {
 	w.quitters = append(w.quitters, q)
 }
 
-func (w *Worker) Quit() {
-	go func() {
-		w.events <- event{
-			state: stateKill,
-		}
-	}()
+// Quit tells the worker to stop its processes and waits for them to quit. It
+// returns acmd.ErrStopTimeout if any had to be killed.
+func (w *Worker) Quit() error {
+	w.lock.Lock()
+	started := w.started
+	w.lock.Unlock()
+
+	if !started {
+		w.stopWatching()
+		return nil
+	}
+
+	reply := make(chan error, 1)
+	w.events <- event{
+		state: stateKill,
+		reply: reply,
+	}
+
+	return <-reply
 }
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zostay/dev-tools/internal/output"
+	"github.com/zostay/dev-tools/pkg/backoff"
+	"github.com/zostay/dev-tools/pkg/config"
+	"github.com/zostay/dev-tools/pkg/lifecycle"
+)
+
+func TestWorkerCrashLoop(t *testing.T) {
+	var out bytes.Buffer
+	mux := output.New(&out, &out)
+
+	cfg := &config.WebTarget{
+		Run:        []string{"sh", "-c", "echo boom; exit 3"},
+		Port:       "18080",
+		WorkingDir: t.TempDir(),
+		RestartPolicy: config.RestartPolicy{
+			MaxRestarts: 2,
+		},
+	}
+
+	var (
+		exits     = make(chan struct{}, 10)
+		crashLoop = make(chan lifecycle.Event, 1)
+		feed      = lifecycle.NewFeed()
+	)
+	feed.Subscribe(func(e lifecycle.Event) {
+		switch e.Phase {
+		case lifecycle.Exit:
+			exits <- struct{}{}
+		case lifecycle.CrashLoop:
+			crashLoop <- e
+		}
+	})
+
+	clock := backoff.NewFakeClock(time.Unix(0, 0))
+	w, err := NewWorker(mux.Source("api"), feed, cfg, clock, new(sync.WaitGroup))
+	if err != nil {
+		t.Fatalf("NewWorker() error: %v", err)
+	}
+
+	// nothing waits on the address of the server here
+	go func() {
+		for range w.AddrListener() {
+		}
+	}()
+
+	w.Start()
+
+	var e lifecycle.Event
+	timeout := time.After(10 * time.Second)
+wait:
+	for {
+		select {
+		case e = <-crashLoop:
+			break wait
+		case <-time.After(10 * time.Millisecond):
+			// the server never runs long enough to reset the count, as the
+			// fake clock only moves forward while waiting to restart
+			if clock.Waiters() > 0 {
+				clock.Advance(time.Hour)
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for the crash loop:\n%s", out.String())
+		}
+	}
+
+	if n := len(exits); n != 3 {
+		t.Errorf("server quit %d times, want 3", n)
+	}
+
+	if e.Target != "api" {
+		t.Errorf("crash loop target = %q, want %q", e.Target, "api")
+	}
+
+	if got := strings.Join(e.Output, "\n"); !strings.Contains(got, "boom") {
+		t.Errorf("crash loop output = %q, want the output of the server", got)
+	}
+
+	if err := w.Quit(); err != nil {
+		t.Errorf("Quit() error: %v", err)
+	}
+}
+
+func TestWorkerBuildDuration(t *testing.T) {
+	dir := t.TempDir()
+	mux := output.New(ioutil.Discard, ioutil.Discard)
+
+	cfg := &config.WebTarget{
+		Build:      []string{"sh", "-c", "while [ ! -f finish ]; do sleep 0.01; done"},
+		Port:       "18080",
+		WorkingDir: dir,
+	}
+
+	var (
+		started = make(chan struct{}, 1)
+		built   = make(chan lifecycle.Event, 1)
+		feed    = lifecycle.NewFeed()
+	)
+	feed.Subscribe(func(e lifecycle.Event) {
+		switch e.Phase {
+		case lifecycle.BuildStart:
+			started <- struct{}{}
+		case lifecycle.BuildOK, lifecycle.BuildFail:
+			built <- e
+		}
+	})
+
+	clock := backoff.NewFakeClock(time.Unix(0, 0))
+	w, err := NewWorker(mux.Source("api"), feed, cfg, clock, new(sync.WaitGroup))
+	if err != nil {
+		t.Fatalf("NewWorker() error: %v", err)
+	}
+
+	w.Start()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the build to start")
+	}
+
+	clock.Advance(3 * time.Second)
+	if err := ioutil.WriteFile(filepath.Join(dir, "finish"), nil, 0644); err != nil {
+		t.Fatalf("unable to write finish file: %v", err)
+	}
+
+	select {
+	case e := <-built:
+		if e.Phase != lifecycle.BuildOK {
+			t.Errorf("build phase = %v, want %v", e.Phase, lifecycle.BuildOK)
+		}
+
+		if e.Duration != 3*time.Second {
+			t.Errorf("build took %v, want 3s", e.Duration)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the build to finish")
+	}
+
+	if err := w.Quit(); err != nil {
+		t.Errorf("Quit() error: %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+// Package targetcmd sets up the commands run for a web target according to the
+// settings of the target.
+package targetcmd
+
+import (
+	"fmt"
+
+	"github.com/zostay/dev-tools/pkg/acmd"
+	"github.com/zostay/dev-tools/pkg/config"
+)
+
+// Configure applies the settings of the named web target that affect how its
+// commands are run, such as environment and how to stop them, to the command.
+func Configure(c *acmd.Cmd, name string, target *config.WebTarget) error {
+	env, err := target.Environ()
+	if err != nil {
+		return fmt.Errorf("web.targets.%s: %w", name, err)
+	}
+
+	stopSignal, err := acmd.ParseSignal(target.StopSignal)
+	if err != nil {
+		return fmt.Errorf("web.targets.%s.stop_signal: %w", name, err)
+	}
+
+	c.Env = env
+	c.StopSignal = stopSignal
+	c.StopTimeout = target.StopTimeout
+	c.NoProcessGroup = target.NoProcessGroup
+
+	return nil
+}
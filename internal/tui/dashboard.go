@@ -0,0 +1,552 @@
+// Package tui shows the state and output of the zxstart targets in a
+// full-screen terminal dashboard.
+package tui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zostay/dev-tools/internal/output"
+	"github.com/zostay/dev-tools/pkg/lifecycle"
+)
+
+// maxLogLines is the most lines of output kept for each target.
+const maxLogLines = 5000
+
+// redrawInterval is how often the dashboard is drawn again, even if nothing
+// has changed, so that it keeps up with the size of the terminal.
+const redrawInterval = time.Second
+
+// These are the states shown for each target.
+const (
+	stateWaiting     = "waiting"
+	stateBuilding    = "building"
+	stateBuilt       = "built"
+	stateBuildFailed = "build failed"
+	stateStarting    = "starting"
+	stateRunning     = "running"
+	stateRestarting  = "restarting"
+	stateCrashed     = "crashed"
+	stateCrashLoop   = "crash loop"
+	stateStopped     = "stopped"
+)
+
+// stateColors are the ANSI colors the states are shown in.
+var stateColors = map[string]int{
+	stateWaiting:     90,
+	stateBuilding:    33,
+	stateBuilt:       33,
+	stateBuildFailed: 31,
+	stateStarting:    33,
+	stateRunning:     32,
+	stateRestarting:  33,
+	stateCrashed:     31,
+	stateCrashLoop:   31,
+	stateStopped:     90,
+}
+
+// Actions are what the keys of the dashboard do to the named target.
+type Actions interface {
+	// Rebuild builds and restarts the target.
+	Rebuild(name string) error
+
+	// Restart restarts the target without building it.
+	Restart(name string) error
+
+	// Open opens the address of the target in a web browser.
+	Open(name string) error
+}
+
+// Target names a target shown on the dashboard.
+type Target struct {
+	Name string
+	Type string
+}
+
+// target is what the dashboard knows about a target.
+type target struct {
+	Target
+
+	state     string
+	addr      string
+	lastBuild time.Duration
+	built     bool
+
+	logs []output.Record
+}
+
+// Dashboard shows the state and output of each target. Its state comes from
+// the lifecycle events and the output records given to it.
+type Dashboard struct {
+	actions Actions
+
+	lock     sync.Mutex
+	targets  []*target
+	byName   map[string]*target
+	selected int
+	scroll   int
+	message  string
+	rows     int
+
+	dirty chan struct{}
+}
+
+// New returns a dashboard for the given targets, which are shown in the order
+// given. Output from any other source is shown as a target of its own.
+func New(targets []Target, actions Actions) *Dashboard {
+	d := Dashboard{
+		actions: actions,
+		byName:  make(map[string]*target, len(targets)),
+		dirty:   make(chan struct{}, 1),
+	}
+
+	for _, t := range targets {
+		d.add(t)
+	}
+
+	return &d
+}
+
+// add adds a target to the dashboard.
+func (d *Dashboard) add(t Target) *target {
+	dt := &target{
+		Target: t,
+		state:  stateWaiting,
+	}
+
+	d.targets = append(d.targets, dt)
+	d.byName[t.Name] = dt
+	return dt
+}
+
+// lookup returns the named target, adding it if it is new. Nothing is known
+// about the state of a new target yet.
+func (d *Dashboard) lookup(name string) *target {
+	if t, ok := d.byName[name]; ok {
+		return t
+	}
+
+	t := d.add(Target{Name: name})
+	t.state = "-"
+	return t
+}
+
+// changed asks for the dashboard to be drawn again.
+func (d *Dashboard) changed() {
+	select {
+	case d.dirty <- struct{}{}:
+	default:
+		// a redraw is waiting already
+	}
+}
+
+// Event updates the state of a target. It may be subscribed to a
+// lifecycle.Feed.
+func (d *Dashboard) Event(e lifecycle.Event) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	t := d.lookup(e.Target)
+	switch e.Phase {
+	case lifecycle.Waiting:
+		t.state = stateWaiting
+	case lifecycle.BuildStart:
+		t.state = stateBuilding
+	case lifecycle.BuildOK:
+		t.state = stateBuilt
+		t.lastBuild, t.built = e.Duration, true
+	case lifecycle.BuildFail:
+		t.state = stateBuildFailed
+		t.lastBuild, t.built = e.Duration, true
+	case lifecycle.RunStart:
+		t.state = stateStarting
+	case lifecycle.Ready:
+		t.state = stateRunning
+		t.addr = e.Addr
+	case lifecycle.Restart:
+		t.state = stateRestarting
+	case lifecycle.Exit:
+		if t.state != stateRestarting && t.state != stateStopped {
+			t.state = stateCrashed
+		}
+	case lifecycle.CrashLoop:
+		t.state = stateCrashLoop
+	case lifecycle.Stopped:
+		t.state = stateStopped
+	}
+
+	d.changed()
+}
+
+// Record adds a line of output to its target. It may be passed to
+// output.Mux.Capture.
+func (d *Dashboard) Record(r output.Record) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	t := d.lookup(r.Source)
+	t.logs = append(t.logs, r)
+	if len(t.logs) > maxLogLines {
+		t.logs = append([]output.Record(nil), t.logs[len(t.logs)-maxLogLines:]...)
+	}
+
+	// keep the lines in view still when scrolled back
+	if d.scroll > 0 && d.targets[d.selected] == t {
+		d.scroll++
+	}
+
+	d.changed()
+}
+
+// IsTerminal returns true if the file is a terminal the dashboard can be
+// shown on.
+func IsTerminal(f *os.File) bool {
+	return isTerminal(int(f.Fd()))
+}
+
+// Run shows the dashboard on the terminal until q or Ctrl-C is pressed or the
+// quit channel is closed. The terminal is put back the way it was before
+// returning.
+func (d *Dashboard) Run(in, out *os.File, quit <-chan struct{}) error {
+	restore, err := makeRaw(int(in.Fd()))
+	if err != nil {
+		return fmt.Errorf("unable to set up the terminal: %w", err)
+	}
+	defer restore()
+
+	// switch to the alternate screen and hide the cursor
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go readKeys(in, keys)
+
+	tick := time.NewTicker(redrawInterval)
+	defer tick.Stop()
+
+	for {
+		d.draw(out)
+
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+
+			if stop := d.key(key); stop {
+				return nil
+			}
+
+		case <-d.dirty:
+		case <-tick.C:
+		case <-quit:
+			return nil
+		}
+	}
+}
+
+// readKeys reads the keys pressed and sends them to the channel, which is
+// closed when nothing more can be read.
+func readKeys(in *os.File, keys chan<- string) {
+	defer close(keys)
+
+	buf := make([]byte, 64)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for _, key := range splitKeys(string(buf[:n])) {
+			keys <- key
+		}
+	}
+}
+
+// splitKeys splits what was read from the terminal into keys. An escape
+// sequence, such as for an arrow key, is kept together as a single key.
+func splitKeys(s string) []string {
+	var keys []string
+	for len(s) > 0 {
+		n := 1
+		if strings.HasPrefix(s, "\x1b[") {
+			n = 2
+			for n < len(s) && (s[n] < 0x40 || s[n] > 0x7e) {
+				n++
+			}
+			if n < len(s) {
+				n++
+			}
+		}
+
+		keys = append(keys, s[:n])
+		s = s[n:]
+	}
+
+	return keys
+}
+
+// key handles a key press. It returns true if the dashboard is to quit.
+func (d *Dashboard) key(key string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	page := d.logHeight(d.rows)
+
+	if key == "q" || key == "\x03" {
+		return true
+	}
+
+	if len(d.targets) == 0 {
+		return false
+	}
+
+	switch key {
+	case "k", "\x1b[A":
+		if d.selected > 0 {
+			d.selected--
+			d.scroll = 0
+		}
+
+	case "j", "\x1b[B":
+		if d.selected < len(d.targets)-1 {
+			d.selected++
+			d.scroll = 0
+		}
+
+	case "u", "\x1b[5~":
+		d.scroll += page
+
+	case "d", "\x1b[6~":
+		d.scroll -= page
+
+	case "g", "\x1b[H":
+		d.scroll = len(d.targets[d.selected].logs)
+
+	case "G", "\x1b[F":
+		d.scroll = 0
+
+	case "b":
+		d.act("Rebuilding", d.actions.Rebuild)
+
+	case "r":
+		d.act("Restarting", d.actions.Restart)
+
+	case "o":
+		d.act("Opening", d.actions.Open)
+	}
+
+	return false
+}
+
+// act runs the action on the selected target in the background, reporting
+// how it went at the bottom of the dashboard.
+func (d *Dashboard) act(doing string, action func(string) error) {
+	name := d.targets[d.selected].Name
+	d.message = fmt.Sprintf("%s %s ...", doing, name)
+
+	go func() {
+		err := action(name)
+
+		d.lock.Lock()
+		defer d.lock.Unlock()
+
+		if err != nil {
+			d.message = fmt.Sprintf("%s %s failed: %v", doing, name, err)
+		} else {
+			d.message = fmt.Sprintf("%s %s ... done", doing, name)
+		}
+		d.changed()
+	}()
+}
+
+// logHeight returns how many lines of output fit on a screen of the given
+// number of rows.
+func (d *Dashboard) logHeight(rows int) int {
+	// the title, table heading, targets, divider, and footer
+	h := rows - len(d.targets) - 4
+	if h < 1 {
+		h = 1
+	}
+	return h
+}
+
+// draw draws the whole dashboard.
+func (d *Dashboard) draw(out *os.File) {
+	cols, rows, err := termSize(int(out.Fd()))
+	if err != nil {
+		cols, rows = 80, 24
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.rows = rows
+
+	var lines []string
+	add := func(line string) {
+		lines = append(lines, line)
+	}
+
+	add(reverse(pad(fmt.Sprintf(" zxstart: %d target(s)", len(d.targets)), cols)))
+
+	nameWidth := len("TARGET")
+	for _, t := range d.targets {
+		if len(t.Name) > nameWidth {
+			nameWidth = len(t.Name)
+		}
+	}
+
+	lead := func(sel, name, typ string) string {
+		return fmt.Sprintf("%s %-*s  %-8s ", sel, nameWidth, name, typ)
+	}
+	row := func(sel, name, typ, state, addr, build string) string {
+		return fmt.Sprintf("%s%-12s %-24s %s", lead(sel, name, typ), state, addr, build)
+	}
+
+	add(bold(clip(row(" ", "TARGET", "TYPE", "STATE", "ADDRESS", "LAST BUILD"), cols)))
+	for i, t := range d.targets {
+		sel := " "
+		if i == d.selected {
+			sel = ">"
+		}
+
+		addr, build := t.addr, "-"
+		if addr == "" {
+			addr = "-"
+		}
+		if t.built {
+			build = t.lastBuild.Round(time.Millisecond).String()
+		}
+
+		line := clip(row(sel, t.Name, t.Type, t.state, addr, build), cols)
+		if color, ok := stateColors[t.state]; ok {
+			rs, at := []rune(line), len([]rune(lead(sel, t.Name, t.Type)))
+			if end := at + len(t.state); end <= len(rs) {
+				line = string(rs[:at]) + colorize(color, t.state) + string(rs[end:])
+			}
+		}
+		if i == d.selected {
+			line = bold(line)
+		}
+		add(line)
+	}
+
+	if len(d.targets) == 0 {
+		for len(lines) < rows {
+			add("")
+		}
+		d.flush(out, lines)
+		return
+	}
+
+	// the output of the selected target fills the rest of the screen
+	t := d.targets[d.selected]
+	height := d.logHeight(rows)
+
+	maxScroll := len(t.logs) - height
+	if maxScroll < 0 {
+		maxScroll = 0
+	}
+	if d.scroll > maxScroll {
+		d.scroll = maxScroll
+	}
+	if d.scroll < 0 {
+		d.scroll = 0
+	}
+
+	end := len(t.logs) - d.scroll
+	start := end - height
+	if start < 0 {
+		start = 0
+	}
+
+	divider := fmt.Sprintf("── %s output ", t.Name)
+	if d.scroll > 0 {
+		divider += fmt.Sprintf("(%d line(s) back) ", d.scroll)
+	}
+	add(dim(clip(divider+strings.Repeat("─", cols), cols)))
+
+	for _, r := range t.logs[start:end] {
+		add(clip(fmt.Sprintf("%s %-5s | %s", r.Time.Format("15:04:05"), r.Kind, sanitize(r.Text)), cols))
+	}
+	for i := end - start; i < height; i++ {
+		add("")
+	}
+
+	footer := d.message
+	if footer == "" {
+		footer = "↑/↓ select  b rebuild  r restart  o open  PgUp/PgDn scroll  q quit"
+	}
+	add(reverse(pad(" "+footer, cols)))
+
+	d.flush(out, lines)
+}
+
+// flush writes the lines over the screen.
+func (d *Dashboard) flush(out *os.File, lines []string) {
+	var buf strings.Builder
+	buf.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString(line)
+		buf.WriteString("\x1b[K")
+	}
+	buf.WriteString("\x1b[J")
+
+	fmt.Fprint(out, buf.String())
+}
+
+// sanitize replaces the control characters of a line of output, which would
+// upset the screen, with spaces.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// clip cuts the line down to the given number of columns.
+func clip(s string, cols int) string {
+	rs := []rune(s)
+	if len(rs) > cols {
+		return string(rs[:cols])
+	}
+	return s
+}
+
+// pad clips or pads the line to exactly the given number of columns.
+func pad(s string, cols int) string {
+	s = clip(s, cols)
+	if n := cols - len([]rune(s)); n > 0 {
+		s += strings.Repeat(" ", n)
+	}
+	return s
+}
+
+// colorize shows the text in the given ANSI color.
+func colorize(color int, s string) string {
+	return fmt.Sprintf("\x1b[%dm%s\x1b[39m", color, s)
+}
+
+// bold shows the text in bold.
+func bold(s string) string {
+	return "\x1b[1m" + s + "\x1b[0m"
+}
+
+// dim shows the text dimmed.
+func dim(s string) string {
+	return "\x1b[2m" + s + "\x1b[0m"
+}
+
+// reverse shows the text in reverse video.
+func reverse(s string) string {
+	return "\x1b[7m" + s + "\x1b[0m"
+}
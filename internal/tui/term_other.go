@@ -0,0 +1,27 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package tui
+
+import (
+	"errors"
+)
+
+// errUnsupported is returned when the terminal cannot be controlled.
+var errUnsupported = errors.New("the dashboard is not supported on this platform")
+
+// makeRaw is not supported on this platform.
+func makeRaw(fd int) (func() error, error) {
+	return nil, errUnsupported
+}
+
+// isTerminal always returns false as terminals are not supported on this
+// platform.
+func isTerminal(fd int) bool {
+	return false
+}
+
+// termSize is not supported on this platform.
+func termSize(fd int) (int, int, error) {
+	return 0, 0, errUnsupported
+}
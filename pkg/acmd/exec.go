@@ -1,21 +1,37 @@
 // Package acmd provides an asynchronous command interface that automatically
-// retries to start the command until it runs. It is intended to be pluggable
-// for specializing.
+// retries starting the command when it fails to start. It is intended to be
+// pluggable for specializing.
 package acmd
 
 import (
+	"context"
 	"errors"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
-	"github.com/zostay/dev-tools/internal/backoff"
+	"github.com/zostay/dev-tools/pkg/backoff"
 	"github.com/zostay/dev-tools/pkg/future"
 )
 
+// DefaultStopTimeout is how long to wait for a command to stop before killing
+// it unless configured otherwise.
+const DefaultStopTimeout = 10 * time.Second
+
+// DefaultStartAttempts is how many times to try starting a command before
+// giving up unless configured otherwise.
+const DefaultStartAttempts = 5
+
+// ErrStopTimeout is the error returned by Wait when the command was killed
+// because it did not stop within the stop timeout.
+var ErrStopTimeout = errors.New("command did not stop in time and was killed")
+
 type state int
 
 const (
@@ -38,6 +54,7 @@ type (
 	ReadyHandler   func(cmd *exec.Cmd) error
 	StartedHandler func(cmd *exec.Cmd) error
 	StopHandler    func(error)
+	EnvHandler     func() []string
 )
 
 type Cmd struct {
@@ -46,6 +63,51 @@ type Cmd struct {
 	StartedHandler StartedHandler
 	StopHandler    StopHandler
 
+	// StopSignal is the signal sent to stop the command. It defaults to
+	// SIGTERM.
+	StopSignal os.Signal
+
+	// StopTimeout is how long to wait for the command to stop after sending
+	// StopSignal before killing it. Zero means DefaultStopTimeout and a
+	// negative value means to wait forever.
+	StopTimeout time.Duration
+
+	// NoProcessGroup turns off starting the command in a process group of
+	// its own. Normally, the command is started in a new process group and
+	// signals are sent to the whole group, so that any processes the command
+	// starts are stopped along with it. Any processes left in the group when
+	// the command exits are killed.
+	NoProcessGroup bool
+
+	// EnvHandler, if set, is called every time the command is about to be
+	// run and returns more environment variables, each written as KEY=VALUE,
+	// to set on top of Env.
+	EnvHandler EnvHandler
+
+	// Env is a list of additional environment variables, each written as
+	// KEY=VALUE, to set for the command on top of the environment of the
+	// current process.
+	Env []string
+
+	// ExpandArgs turns on replacing $NAME and ${NAME} in the arguments of the
+	// command with the value of the variable in the environment the command
+	// is run with, like a shell would. Unset variables are replaced with
+	// nothing.
+	ExpandArgs bool
+
+	// Retry is how starting the command is retried when it fails to start.
+	// Each retry is logged unless Retry.Notify is set. Retry.MaxAttempts
+	// defaults to DefaultStartAttempts and a negative value means there is no
+	// limit. Once it gives up, Wait returns the error starting the command.
+	Retry backoff.Policy
+
+	// Stdout and Stderr are where the output of the command is written. They
+	// default to the standard output and standard error of the current
+	// process. If either has a Flush method, it is called after the command
+	// exits.
+	Stdout io.Writer
+	Stderr io.Writer
+
 	workingDir string
 	cmdLine    []string
 	done       *sync.WaitGroup
@@ -53,7 +115,7 @@ type Cmd struct {
 
 	quitter       func(error)
 	events        chan event
-	readyState    backoff.InscribedState
+	backoff       *backoff.Backoff
 	eventlooponce *sync.Once
 	startonce     *sync.Once
 	stoponce      *sync.Once
@@ -196,7 +258,9 @@ func (c *Cmd) start() {
 		}
 	}
 
-	q := backoff.Generic(c.done, func() error {
+	c.backoff = backoff.New(c.retryPolicy())
+
+	q := c.retry(func() error {
 		cmd, err := c.buildCmd()
 		if err != nil {
 			c.logger.Printf("Command %q failed restart: %v\n", c.String(), err)
@@ -214,23 +278,138 @@ func (c *Cmd) start() {
 	c.makeQuitter(q)
 }
 
+// retryPolicy returns Retry with its defaults filled in.
+func (c *Cmd) retryPolicy() backoff.Policy {
+	p := c.Retry
+
+	switch {
+	case p.MaxAttempts == 0:
+		p.MaxAttempts = DefaultStartAttempts
+	case p.MaxAttempts < 0:
+		p.MaxAttempts = 0
+	}
+
+	if p.Notify == nil {
+		p.Notify = func(_ error, _ int, wait time.Duration) {
+			c.logger.Printf("Try again in %v\n", wait)
+		}
+	}
+
+	return p
+}
+
+// retry calls op in the background until it succeeds, waiting after each
+// failure as set by Retry. If it gives up, the command is stopped with the last
+// error. It returns a function that stops trying.
+func (c *Cmd) retry(op func() error) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c.done.Add(1)
+	go func() {
+		defer c.done.Done()
+		err := c.backoff.Retry(ctx, op)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		c.logger.Printf("Giving up on starting %q\n", c.String())
+		c.stoponce.Do(func() {
+			c.events <- event{
+				state: stateKill,
+				err:   err,
+			}
+		})
+	}()
+
+	return cancel
+}
+
 func (c *Cmd) buildCmd() (*exec.Cmd, error) {
-	c.logger.Printf("Run> %s ...\n", c.String())
+	env := c.Env
+	if c.EnvHandler != nil {
+		env = append(append([]string{}, env...), c.EnvHandler()...)
+	}
+
+	cmdLine := c.cmdLine
+	if c.ExpandArgs {
+		cmdLine = expandArgs(cmdLine, env)
+	}
+
+	c.logger.Printf("Run> %s ...\n", strings.Join(cmdLine, " "))
 
 	var cmd *exec.Cmd
-	if len(c.cmdLine) > 1 {
-		cmd = exec.Command(c.cmdLine[0], c.cmdLine[1:]...)
+	if len(cmdLine) > 1 {
+		cmd = exec.Command(cmdLine[0], cmdLine[1:]...)
 	} else {
-		cmd = exec.Command(c.cmdLine[0])
+		cmd = exec.Command(cmdLine[0])
 	}
 
 	cmd.Dir = c.workingDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	if !c.NoProcessGroup {
+		setProcessGroup(cmd)
+	}
+
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = c.StdoutWriter()
+	cmd.Stderr = c.StderrWriter()
 
 	return cmd, nil
 }
 
+// expandArgs replaces the variables in the arguments with their values in the
+// environment of the current process with env set on top of it.
+func expandArgs(args, env []string) []string {
+	vars := make(map[string]string, len(env))
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	lookup := func(n string) string {
+		if v, ok := vars[n]; ok {
+			return v
+		}
+		return os.Getenv(n)
+	}
+
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = os.Expand(arg, lookup)
+	}
+
+	return expanded
+}
+
+// StdoutWriter returns where to write the standard output of the command.
+func (c *Cmd) StdoutWriter() io.Writer {
+	if c.Stdout != nil {
+		return c.Stdout
+	}
+	return os.Stdout
+}
+
+// StderrWriter returns where to write the standard error of the command.
+func (c *Cmd) StderrWriter() io.Writer {
+	if c.Stderr != nil {
+		return c.Stderr
+	}
+	return os.Stderr
+}
+
+// flush writes out any output held by the writers of the command.
+func (c *Cmd) flush() {
+	for _, w := range []io.Writer{c.Stdout, c.Stderr} {
+		if f, ok := w.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				c.logger.Printf("Unable to write output of %q: %v\n", c.String(), err)
+			}
+		}
+	}
+}
+
 func (c *Cmd) ready(cmd *exec.Cmd) {
 	if c.ReadyHandler != nil {
 		if err := c.ReadyHandler(cmd); err != nil {
@@ -246,10 +425,11 @@ func (c *Cmd) ready(cmd *exec.Cmd) {
 		}
 	}
 
-	q := backoff.Inscribed(c.done, &c.readyState, func() error {
+	q := c.retry(func() error {
 		err := cmd.Start()
 		if err != nil {
-			c.logger.Printf("Error building with %q: %v", c.String(), err)
+			c.logger.Printf("Error starting %q: %v\n", c.String(), err)
+			cmd = c.recreate(cmd)
 			return err
 		}
 
@@ -268,16 +448,88 @@ func (c *Cmd) ready(cmd *exec.Cmd) {
 	c.makeQuitter(q)
 }
 
+// recreate returns a copy of the command that has not been started, as a
+// command can only be started once, even if it failed to start.
+func (c *Cmd) recreate(cmd *exec.Cmd) *exec.Cmd {
+	next := exec.Command(cmd.Args[0], cmd.Args[1:]...)
+	next.Dir = cmd.Dir
+	next.Env = cmd.Env
+	next.Stdin = cmd.Stdin
+	next.Stdout = cmd.Stdout
+	next.Stderr = cmd.Stderr
+	next.ExtraFiles = cmd.ExtraFiles
+	next.SysProcAttr = cmd.SysProcAttr
+	return next
+}
+
+// signal sends the signal to the command and, unless NoProcessGroup is set,
+// every other process in its process group.
+func (c *Cmd) signal(cmd *exec.Cmd, sig os.Signal) error {
+	if c.NoProcessGroup {
+		return cmd.Process.Signal(sig)
+	}
+
+	return signalProcessGroup(cmd.Process, sig)
+}
+
+// killLeftovers kills any processes remaining in the process group of the
+// command after it exits, so they do not hang on to ports and such.
+func (c *Cmd) killLeftovers(cmd *exec.Cmd) {
+	if c.NoProcessGroup || !processGroupAlive(cmd.Process) {
+		return
+	}
+
+	c.logger.Printf("Killing processes left behind by %q\n", c.String())
+	if err := signalProcessGroup(cmd.Process, syscall.SIGKILL); err != nil && err != os.ErrProcessDone {
+		c.logger.Printf("Unable to kill processes left behind by %q: %v\n", c.String(), err)
+	}
+}
+
 func (c *Cmd) started(cmd *exec.Cmd) {
-	once := new(sync.Once)
+	var (
+		once   = new(sync.Once)
+		exited = make(chan struct{})
+		forced int32
+	)
+
+	sig := c.StopSignal
+	if sig == nil {
+		sig = syscall.SIGTERM
+	}
+
+	timeout := c.StopTimeout
+	if timeout == 0 {
+		timeout = DefaultStopTimeout
+	}
+
 	q := func() {
 		once.Do(func() {
-			if err := cmd.Process.Signal(syscall.SIGHUP); err != nil {
-				c.logger.Printf("Error sending SIGHUP to %q: %v\n", c.String(), err)
-				if err := cmd.Process.Kill(); err != nil {
+			if err := c.signal(cmd, sig); err != nil {
+				c.logger.Printf("Error sending %v to %q: %v\n", sig, c.String(), err)
+				if err := c.signal(cmd, syscall.SIGKILL); err != nil {
 					c.logger.Printf("Unable to kill %q: %v\n", c.String(), err)
 				}
+				return
+			}
+
+			if timeout < 0 {
+				return
 			}
+
+			c.done.Add(1)
+			go func() {
+				defer c.done.Done()
+				select {
+				case <-exited:
+				case <-time.After(timeout):
+					c.logger.Printf("Command %q did not stop within %v, killing it\n", c.String(), timeout)
+					atomic.StoreInt32(&forced, 1)
+					if err := c.signal(cmd, syscall.SIGKILL); err != nil {
+						atomic.StoreInt32(&forced, 0)
+						c.logger.Printf("Unable to kill %q: %v\n", c.String(), err)
+					}
+				}
+			}()
 		})
 	}
 
@@ -285,6 +537,16 @@ func (c *Cmd) started(cmd *exec.Cmd) {
 	go func() {
 		defer c.done.Done()
 		err := cmd.Wait()
+		close(exited)
+		c.killLeftovers(cmd)
+		if atomic.LoadInt32(&forced) != 0 {
+			err = ErrStopTimeout
+		}
+
+		if c.StopHandler != nil {
+			c.StopHandler(err)
+		}
+		c.flush()
 		c.result.Keep(struct{}{}, err)
 	}()
 
@@ -308,3 +570,19 @@ func (c *Cmd) started(cmd *exec.Cmd) {
 		}
 	}
 }
+
+// ExitCode returns the exit code of a command given the error returned by
+// Wait. It is 0 for no error and -1 when the command did not exit on its own,
+// such as when it was killed by a signal or never started.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
@@ -0,0 +1,60 @@
+package acmd
+
+import (
+	"bytes"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zostay/dev-tools/pkg/backoff"
+)
+
+func TestStartRetryGivesUp(t *testing.T) {
+	var out bytes.Buffer
+	logger := log.New(&out, "", 0)
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	c, err := Command("", []string{missing}, new(sync.WaitGroup), logger)
+	if err != nil {
+		t.Fatalf("Command() error: %v", err)
+	}
+
+	clock := backoff.NewFakeClock(time.Unix(0, 0))
+	c.Retry = backoff.Policy{
+		Strategy:    backoff.Constant{Wait: time.Second},
+		MaxAttempts: 3,
+		Clock:       clock,
+	}
+
+	c.Start()
+
+	// the first two attempts fail and wait, the third gives up
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(time.Second)
+	}
+
+	result := make(chan error, 1)
+	go func() { result <- c.Wait() }()
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("Wait() succeeded, want the error starting the command")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the command to give up")
+	}
+
+	log := out.String()
+	if n := strings.Count(log, "Error starting"); n != 3 {
+		t.Errorf("tried to start %d times, want 3:\n%s", n, log)
+	}
+
+	if !strings.Contains(log, "Giving up on starting") {
+		t.Errorf("giving up was not logged:\n%s", log)
+	}
+}
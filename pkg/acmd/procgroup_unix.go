@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package acmd
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes the command start in a new process group of its own.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalProcessGroup sends the signal to every process in the process group
+// led by the given process.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	ssig, ok := sig.(syscall.Signal)
+	if !ok {
+		return p.Signal(sig)
+	}
+
+	err := syscall.Kill(-p.Pid, ssig)
+	if err == syscall.ESRCH {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// processGroupAlive returns true if any process remains in the process group
+// led by the given process.
+func processGroupAlive(p *os.Process) bool {
+	return syscall.Kill(-p.Pid, 0) == nil
+}
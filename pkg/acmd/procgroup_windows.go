@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package acmd
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing as process groups are not supported here.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcessGroup signals only the given process as process groups are not
+// supported here.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
+
+// processGroupAlive always returns false as process groups are not supported
+// here.
+func processGroupAlive(p *os.Process) bool {
+	return false
+}
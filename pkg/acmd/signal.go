@@ -0,0 +1,33 @@
+package acmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// signals are the signals that may be named by ParseSignal.
+var signals = map[string]os.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// ParseSignal returns the signal with the given name. The name is case
+// insensitive and the SIG prefix is optional, so SIGINT, INT, and int all name
+// the same signal. An empty name returns a nil signal, which means the default.
+func ParseSignal(name string) (os.Signal, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	n := strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	if sig, ok := signals[n]; ok {
+		return sig, nil
+	}
+
+	return nil, fmt.Errorf("unknown signal %q", name)
+}
@@ -0,0 +1,168 @@
+// Package backoff retries work that fails, waiting longer after each failure
+// in a row. The waits are taken from a Clock, so that retrying can be tested
+// with a FakeClock without waiting for real.
+package backoff
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultStrategy is the strategy of a Policy that does not set one. It waits
+// 1s after the first failure, doubling the wait after each failure in a row up
+// to 16s.
+var DefaultStrategy Strategy = Exponential{
+	Initial: time.Second,
+	Max:     16 * time.Second,
+}
+
+// Policy configures how failures are retried.
+type Policy struct {
+	// Strategy works out how long to wait after each failure. It defaults to
+	// DefaultStrategy.
+	Strategy Strategy
+
+	// MaxAttempts is the most times to try before giving up. Zero means
+	// there is no limit.
+	MaxAttempts int
+
+	// MaxElapsed is the longest time from the first failure in a row to the
+	// next try before giving up. Zero means there is no limit.
+	MaxElapsed time.Duration
+
+	// Clock is what waits between tries. It defaults to System.
+	Clock Clock
+
+	// Notify, if set, is called after each failure that will be tried again
+	// with the error, the number of failures in a row, and how long it will
+	// wait before trying again.
+	Notify func(err error, failures int, wait time.Duration)
+}
+
+// Backoff keeps count of the failures in a row to work out how long to wait
+// before trying again according to its policy. It is safe to use from more
+// than one goroutine.
+type Backoff struct {
+	policy Policy
+
+	lock     sync.Mutex
+	failures int
+	first    time.Time
+	prev     time.Duration
+}
+
+// New returns a backoff with no failures that follows the policy.
+func New(p Policy) *Backoff {
+	if p.Strategy == nil {
+		p.Strategy = DefaultStrategy
+	}
+
+	if p.Clock == nil {
+		p.Clock = System
+	}
+
+	return &Backoff{policy: p}
+}
+
+// Clock returns the clock of the backoff.
+func (b *Backoff) Clock() Clock {
+	return b.policy.Clock
+}
+
+// Failures returns the number of failures in a row.
+func (b *Backoff) Failures() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.failures
+}
+
+// Reset forgets the failures, such as after a success.
+func (b *Backoff) Reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures = 0
+	b.first = time.Time{}
+	b.prev = 0
+}
+
+// Fail counts a failure with the given error and returns how long to wait
+// before trying again. It returns false instead if the policy says to give
+// up.
+func (b *Backoff) Fail(err error) (time.Duration, bool) {
+	wait, failures, ok := b.fail()
+	if ok && b.policy.Notify != nil {
+		b.policy.Notify(err, failures, wait)
+	}
+
+	return wait, ok
+}
+
+// fail counts a failure and works out the wait.
+func (b *Backoff) fail() (time.Duration, int, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.policy.Clock.Now()
+
+	b.failures++
+	if b.failures == 1 {
+		b.first = now
+	}
+
+	if b.policy.MaxAttempts > 0 && b.failures >= b.policy.MaxAttempts {
+		return 0, b.failures, false
+	}
+
+	wait := b.policy.Strategy.Delay(b.failures, b.prev)
+	if b.policy.MaxElapsed > 0 && now.Add(wait).Sub(b.first) > b.policy.MaxElapsed {
+		return 0, b.failures, false
+	}
+
+	b.prev = wait
+	return wait, b.failures, true
+}
+
+// Wait waits for the duration to pass on the clock of the backoff. It returns
+// the error of the context if it is done first.
+func (b *Backoff) Wait(ctx context.Context, d time.Duration) error {
+	select {
+	case <-b.policy.Clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Retry calls op until it succeeds, waiting after each failure. It returns the
+// last error of op if the policy says to give up or the error of the context
+// if it is done first. The failures are forgotten once op succeeds.
+func (b *Backoff) Retry(ctx context.Context, op func() error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := op()
+		if err == nil {
+			b.Reset()
+			return nil
+		}
+
+		wait, ok := b.Fail(err)
+		if !ok {
+			return err
+		}
+
+		if err := b.Wait(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Retry calls op until it succeeds, waiting after each failure according to
+// the policy. See Backoff.Retry.
+func Retry(ctx context.Context, p Policy, op func() error) error {
+	return New(p).Retry(ctx, op)
+}
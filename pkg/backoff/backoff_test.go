@@ -0,0 +1,188 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var errFailed = errors.New("failed")
+
+// retry runs Retry in the background with the policy, advancing the fake clock
+// of the policy through each wait, and returns the result, the number of calls
+// to op, and the waits notified.
+func retry(t *testing.T, p Policy, op func() error) (error, int, []time.Duration) {
+	t.Helper()
+
+	clock := p.Clock.(*FakeClock)
+
+	var (
+		calls int
+		waits []time.Duration
+		done  = make(chan error, 1)
+	)
+
+	p.Notify = func(_ error, _ int, wait time.Duration) {
+		waits = append(waits, wait)
+	}
+
+	go func() {
+		done <- Retry(context.Background(), p, func() error {
+			calls++
+			return op()
+		})
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-done:
+			return err, calls, waits
+		case <-time.After(10 * time.Millisecond):
+			if clock.Waiters() > 0 {
+				clock.Advance(time.Hour)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for Retry")
+		}
+	}
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+
+	n := 0
+	err, calls, waits := retry(t, Policy{Clock: clock}, func() error {
+		n++
+		if n < 3 {
+			return errFailed
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Errorf("Retry() error: %v", err)
+	}
+
+	if calls != 3 {
+		t.Errorf("op called %d times, want 3", calls)
+	}
+
+	if want := []time.Duration{time.Second, 2 * time.Second}; !reflect.DeepEqual(waits, want) {
+		t.Errorf("waits = %v, want %v", waits, want)
+	}
+}
+
+func TestRetryMaxAttempts(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+
+	err, calls, waits := retry(t, Policy{Clock: clock, MaxAttempts: 4}, func() error {
+		return errFailed
+	})
+
+	if err != errFailed {
+		t.Errorf("Retry() error = %v, want %v", err, errFailed)
+	}
+
+	if calls != 4 {
+		t.Errorf("op called %d times, want 4", calls)
+	}
+
+	if len(waits) != 3 {
+		t.Errorf("waited %d times, want 3", len(waits))
+	}
+}
+
+func TestRetryMaxElapsed(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+
+	p := Policy{
+		Strategy:   Constant{Wait: 2 * time.Second},
+		MaxElapsed: 5 * time.Second,
+		Clock:      clock,
+	}
+
+	b := New(p)
+	for i, want := range []bool{true, true, false} {
+		wait, ok := b.Fail(errFailed)
+		if ok != want {
+			t.Fatalf("Fail() #%d ok = %v, want %v", i+1, ok, want)
+		}
+
+		if ok && wait != 2*time.Second {
+			t.Errorf("Fail() #%d wait = %v, want 2s", i+1, wait)
+		}
+
+		clock.Advance(wait)
+	}
+
+	b.Reset()
+	if _, ok := b.Fail(errFailed); !ok {
+		t.Error("Fail() after Reset() gave up, want a wait")
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		clock.BlockUntil(1)
+		cancel()
+	}()
+
+	err := Retry(ctx, Policy{Clock: clock}, func() error { return errFailed })
+	if err != context.Canceled {
+		t.Errorf("Retry() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestExponential(t *testing.T) {
+	e := Exponential{Initial: time.Second, Max: 10 * time.Second}
+
+	want := []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		10 * time.Second, 10 * time.Second,
+	}
+	for i, w := range want {
+		if got := e.Delay(i+1, 0); got != w {
+			t.Errorf("Delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	e.Jitter = 0.5
+	e.Rand = func() float64 { return 0.5 }
+	if got, want := e.Delay(2, 0), 2500*time.Millisecond; got != want {
+		t.Errorf("Delay(2) with jitter = %v, want %v", got, want)
+	}
+}
+
+func TestDecorrelatedJitter(t *testing.T) {
+	j := DecorrelatedJitter{
+		Base: time.Second,
+		Max:  10 * time.Second,
+		Rand: func() float64 { return 0.5 },
+	}
+
+	tests := []struct {
+		prev, want time.Duration
+	}{
+		{0, 2 * time.Second},
+		{2 * time.Second, 3500 * time.Millisecond},
+		{3500 * time.Millisecond, 5750 * time.Millisecond},
+		{8 * time.Second, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := j.Delay(1, tt.prev); got != tt.want {
+			t.Errorf("Delay(prev %v) = %v, want %v", tt.prev, got, tt.want)
+		}
+	}
+
+	j.Rand = func() float64 { return 0 }
+	if got := j.Delay(1, 5*time.Second); got != time.Second {
+		t.Errorf("Delay() with no randomness = %v, want the base of 1s", got)
+	}
+}
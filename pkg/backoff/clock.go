@@ -0,0 +1,115 @@
+package backoff
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for it to pass.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel that receives the time once the duration has
+	// passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// System is the clock of the system.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// FakeClock is a clock that only moves when told to, so that waiting can be
+// tested without taking the time to wait.
+type FakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+	waiting chan struct{}
+}
+
+type fakeWaiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// NewFakeClock returns a fake clock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:     now,
+		waiting: make(chan struct{}),
+	}
+}
+
+// Now returns the time of the fake clock.
+func (f *FakeClock) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+// After returns a channel that receives the time once the fake clock has been
+// advanced by the duration. A duration of zero or less has passed already.
+func (f *FakeClock) After(d time.Duration) <-chan time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+
+	f.waiters = append(f.waiters, fakeWaiter{f.now.Add(d), ch})
+	close(f.waiting)
+	f.waiting = make(chan struct{})
+
+	return ch
+}
+
+// Advance moves the fake clock forward by the duration, sending the time to
+// every channel from After that has waited long enough.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.now = f.now.Add(d)
+
+	waiters := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.until.After(f.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+
+		w.ch <- f.now
+	}
+	f.waiters = waiters
+}
+
+// Waiters returns how many channels from After are still waiting.
+func (f *FakeClock) Waiters() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil waits until at least n channels from After are waiting, so that
+// a test knows the code under test is waiting before it advances the clock.
+func (f *FakeClock) BlockUntil(n int) {
+	for {
+		f.lock.Lock()
+		count, waiting := len(f.waiters), f.waiting
+		f.lock.Unlock()
+
+		if count >= n {
+			return
+		}
+
+		<-waiting
+	}
+}
@@ -0,0 +1,98 @@
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Strategy works out how long to wait before trying again.
+type Strategy interface {
+	// Delay returns how long to wait after the given number of failures in a
+	// row, counting from one for the first failure. The previous wait is
+	// given, which is zero after the first failure.
+	Delay(failures int, prev time.Duration) time.Duration
+}
+
+// Constant waits the same time after every failure.
+type Constant struct {
+	// Wait is the time to wait.
+	Wait time.Duration
+}
+
+// Delay returns the constant wait.
+func (c Constant) Delay(int, time.Duration) time.Duration {
+	return c.Wait
+}
+
+// Exponential doubles the wait with every failure in a row.
+type Exponential struct {
+	// Initial is the wait after the first failure.
+	Initial time.Duration
+
+	// Max is the longest wait, not counting jitter. Zero means there is no
+	// limit.
+	Max time.Duration
+
+	// Jitter is the fraction of the wait, from 0 to 1, added at random.
+	Jitter float64
+
+	// Rand returns a random number from 0 up to 1. It defaults to
+	// rand.Float64.
+	Rand func() float64
+}
+
+// Delay returns the initial wait doubled for each failure after the first.
+func (e Exponential) Delay(failures int, _ time.Duration) time.Duration {
+	d := e.Initial
+	for i := 1; i < failures && (e.Max <= 0 || d < e.Max); i++ {
+		d *= 2
+	}
+
+	if e.Max > 0 && d > e.Max {
+		d = e.Max
+	}
+
+	if e.Jitter > 0 {
+		d += time.Duration(random(e.Rand) * e.Jitter * float64(d))
+	}
+
+	return d
+}
+
+// DecorrelatedJitter picks each wait at random from Base up to three times the
+// previous wait, so that the waits grow about as fast as Exponential, but
+// clients failing together soon spread apart.
+type DecorrelatedJitter struct {
+	// Base is the shortest wait and the wait the first is picked from.
+	Base time.Duration
+
+	// Max is the longest wait. Zero means there is no limit.
+	Max time.Duration
+
+	// Rand returns a random number from 0 up to 1. It defaults to
+	// rand.Float64.
+	Rand func() float64
+}
+
+// Delay returns a random wait from Base up to three times the previous wait.
+func (j DecorrelatedJitter) Delay(_ int, prev time.Duration) time.Duration {
+	if prev < j.Base {
+		prev = j.Base
+	}
+
+	d := j.Base + time.Duration(random(j.Rand)*float64(3*prev-j.Base))
+	if j.Max > 0 && d > j.Max {
+		d = j.Max
+	}
+
+	return d
+}
+
+// random returns a random number from 0 up to 1 from the given function or
+// else from rand.Float64.
+func random(fn func() float64) float64 {
+	if fn == nil {
+		return rand.Float64()
+	}
+	return fn()
+}
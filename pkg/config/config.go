@@ -17,9 +17,16 @@ const ZxPrefix = "ZX"
 type Config struct {
 	App string
 
+	// StateDir is the directory to keep state for the project in, such as
+	// caches. It defaults to DefaultStateDir.
+	StateDir string `mapstructure:"state_dir"`
+
 	Install   `mapstructure:"install"`
 	SQLBoiler `mapstructure:"sqlboiler"`
 	Web
+
+	// Watches are the watches zxwatch can run by name.
+	Watches map[string]WatchCommand
 }
 
 // Init initializes the ZX configuration. If verbosity is set to a non-zero
@@ -71,10 +78,8 @@ func Init(verbosity int) {
 		fmt.Fprintln(os.Stderr, "Not loading environment config. Please set the \"app\" or \"env_prefix\" key in .zx.yaml.")
 	}
 
-	// TODO The value interpolation for .run keys is pretty half-assed and needs
-	// fixing.
-
-	// Expand some keys
+	// Make the arguments of .run keys strings. They are interpolated when the
+	// command is run, so that variables like $PORT are set.
 	for _, k := range viper.AllKeys() {
 		switch v := viper.Get(k).(type) {
 		case []interface{}:
@@ -82,11 +87,7 @@ func Init(verbosity int) {
 				vs := make([]string, len(v))
 				for i, iv := range v {
 					if siv, ok := iv.(string); ok {
-						if strings.ContainsRune(siv, '$') {
-							vs[i] = ExpandStdValue(siv)
-						} else {
-							vs[i] = siv
-						}
+						vs[i] = siv
 					} else {
 						vs[i] = fmt.Sprintf("%s", iv)
 					}
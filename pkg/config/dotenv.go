@@ -0,0 +1,109 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvVar is a single environment variable setting.
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// ReadEnvFile reads a dotenv file and returns the variables it sets in the
+// order they are set. Each line is of the form NAME=VALUE and may be preceded
+// by export. Blank lines and lines starting with # are ignored. Values may be
+// single quoted, which are taken literally, or double quoted, in which \n, \r,
+// \t, \", and \\ are unescaped. Unquoted values are trimmed and anything after
+// " #" is ignored as a comment.
+func ReadEnvFile(path string) ([]EnvVar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		vars   = make([]EnvVar, 0)
+		s      = bufio.NewScanner(f)
+		lineno = 0
+	)
+
+	for s.Scan() {
+		lineno++
+
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		eq := strings.IndexRune(line, '=')
+		if eq < 1 {
+			return nil, fmt.Errorf("%s:%d: expected NAME=VALUE", path, lineno)
+		}
+
+		name := strings.TrimSpace(line[:eq])
+		value, err := envFileValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineno, err)
+		}
+
+		vars = append(vars, EnvVar{name, value})
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+// envFileValue parses the value part of a dotenv line.
+func envFileValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, "'"):
+		end := strings.IndexRune(v[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quoted value")
+		}
+		return v[1 : end+1], nil
+
+	case strings.HasPrefix(v, `"`):
+		sb := new(strings.Builder)
+		escaped := false
+		for _, r := range v[1:] {
+			switch {
+			case escaped:
+				switch r {
+				case 'n':
+					sb.WriteRune('\n')
+				case 'r':
+					sb.WriteRune('\r')
+				case 't':
+					sb.WriteRune('\t')
+				default:
+					sb.WriteRune(r)
+				}
+				escaped = false
+			case r == '\\':
+				escaped = true
+			case r == '"':
+				return sb.String(), nil
+			default:
+				sb.WriteRune(r)
+			}
+		}
+		return "", fmt.Errorf("unterminated double quoted value")
+
+	default:
+		if i := strings.Index(v, " #"); i >= 0 {
+			v = v[:i]
+		}
+		return strings.TrimSpace(v), nil
+	}
+}
@@ -1,6 +1,86 @@
 package config
 
+import "time"
+
+// These are the ways a FileWatch may watch for changes.
+const (
+	// WatchAuto uses fsnotify, falling back to polling if fsnotify cannot be
+	// setup. This is the default.
+	WatchAuto = "auto"
+
+	// WatchFSNotify uses the file system notifications of the OS.
+	WatchFSNotify = "fsnotify"
+
+	// WatchPoll scans the watched files periodically for changes. This works
+	// on file systems that do not deliver notifications, like some Docker
+	// bind mounts and network file systems.
+	WatchPoll = "poll"
+)
+
+// These are the actions a FileWatch may take when a watched file changes.
+const (
+	// WatchRebuild runs the build of the target and then restarts it. This is
+	// the default.
+	WatchRebuild = "rebuild"
+
+	// WatchRestart restarts the target without building it.
+	WatchRestart = "restart"
+
+	// WatchRun runs the command of the watch, then takes the action named by
+	// Then, if any.
+	WatchRun = "run"
+)
+
 type FileWatch struct {
 	Targets []string
+
+	// GoPackage is a Go package to watch, e.g., ./cmd/server, instead of
+	// Targets. It is found from the working directory of the target. The
+	// directories of the package and every package of the main module it
+	// depends upon are watched, along with go.mod, go.sum, and any files
+	// matching its embed patterns. What is watched is worked out again after every
+	// successful build.
+	GoPackage string `mapstructure:"go_package"`
+
+	// Filters are patterns matching the paths to watch. Each is matched
+	// against the path relative to the target being watched and then against
+	// the whole path. With no filters, every path is watched.
 	Filters []string
+
+	// Excludes are patterns matching paths to ignore. A directory matching one
+	// of these patterns is not watched at all, nor is anything inside it.
+	Excludes []string
+
+	// IgnoreFiles turns on ignoring the paths listed in the .gitignore and
+	// .zxignore files found in the working directory and the watched
+	// directories. The .git directory is ignored as well.
+	IgnoreFiles bool `mapstructure:"ignore_files"`
+
+	// Ops are the kinds of changes to watch for. Each is one of create, write,
+	// remove, rename, or chmod. It defaults to everything but chmod.
+	Ops []string
+
+	// ContentHash turns on comparing the content of changed files to what it
+	// was before, so that saving a file without changing it is ignored. The
+	// hashes are kept in the state directory between runs.
+	ContentHash bool `mapstructure:"content_hash"`
+
+	// Backend is how to watch for changes. It is one of auto, fsnotify, or
+	// poll and defaults to auto.
+	Backend string
+
+	// PollInterval is how often to scan for changes when polling. It defaults
+	// to 1s.
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+
+	// Action is what to do when a watched file changes. It is one of rebuild,
+	// restart, or run and defaults to rebuild.
+	Action string
+
+	// Command is the command to run when Action is run.
+	Command []string
+
+	// Then is the action to take after Command succeeds when Action is run.
+	// It is either rebuild or restart. If not set, nothing more is done.
+	Then string
 }
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// These are the defaults for rotating the log files of web targets.
+const (
+	// DefaultLogMaxSize is the size in bytes a log file may grow to before it
+	// is rotated.
+	DefaultLogMaxSize = 10 * 1024 * 1024
+
+	// DefaultLogMaxFiles is the number of rotated log files kept for each
+	// target.
+	DefaultLogMaxFiles = 3
+)
+
+// WebLogs configures the log files zxstart writes the output of each web
+// target to.
+type WebLogs struct {
+	// Disable turns off writing log files.
+	Disable bool
+
+	// MaxSize is the size in bytes a log file may grow to before it is
+	// rotated. It defaults to DefaultLogMaxSize.
+	MaxSize int64 `mapstructure:"max_size"`
+
+	// MaxFiles is the number of rotated log files to keep for each target,
+	// not counting the one being written. It defaults to DefaultLogMaxFiles.
+	MaxFiles int `mapstructure:"max_files"`
+}
+
+// LogDir returns the directory the log files of the web targets are kept in,
+// creating it if it does not exist yet.
+func LogDir() (string, error) {
+	state, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(state, "logs")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
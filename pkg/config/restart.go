@@ -0,0 +1,60 @@
+package config
+
+import "time"
+
+// These are the restart policies of a server target.
+const (
+	// RestartAlways restarts the server whenever it quits.
+	RestartAlways = "always"
+
+	// RestartOnFailure restarts the server only when it quits with an error.
+	RestartOnFailure = "on-failure"
+
+	// RestartNever leaves the server stopped when it quits.
+	RestartNever = "never"
+)
+
+// These are the defaults of the restart policy of a server target.
+const (
+	DefaultMaxRestarts     = 5
+	DefaultRestartDelay    = time.Second
+	DefaultRestartMaxDelay = 30 * time.Second
+	DefaultMinUptime       = 10 * time.Second
+	DefaultCrashLogLines   = 20
+)
+
+// RestartPolicy configures what happens when the server of a target quits on
+// its own.
+type RestartPolicy struct {
+	// Restart is one of the restart policies: always, on-failure, or never.
+	// It defaults to always.
+	Restart string
+
+	// MaxRestarts is the most times in a row the server is restarted after
+	// quitting in less than MinUptime. Once it has been restarted this many
+	// times, the server is in a crash loop and it is left stopped until the
+	// next rebuild or restart. It defaults to 5 and a negative value means
+	// there is no limit.
+	MaxRestarts int `mapstructure:"max_restarts"`
+
+	// RestartDelay is how long to wait before the first restart. The wait
+	// doubles with each restart in a row after that. It defaults to 1s.
+	RestartDelay time.Duration `mapstructure:"restart_delay"`
+
+	// RestartMaxDelay is the longest to wait before a restart. It defaults to
+	// 30s.
+	RestartMaxDelay time.Duration `mapstructure:"restart_max_delay"`
+
+	// RestartJitter is the fraction of the wait before a restart, from 0 to
+	// 1, that is added at random, so that servers failing together do not
+	// restart together. It defaults to 0.
+	RestartJitter float64 `mapstructure:"restart_jitter"`
+
+	// MinUptime is how long the server must run before it is no longer
+	// considered to be crashing. It defaults to 10s.
+	MinUptime time.Duration `mapstructure:"min_uptime"`
+
+	// CrashLogLines is how many of the last lines of output of the server to
+	// report when it is found to be in a crash loop. It defaults to 20.
+	CrashLogLines int `mapstructure:"crash_log_lines"`
+}
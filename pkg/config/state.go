@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultStateDir is the directory, relative to the project, in which to keep
+// state for the project unless configured otherwise.
+const DefaultStateDir = ".zx"
+
+// StateDir returns the directory in which to keep state for the project,
+// creating it if it does not exist yet. A new state directory is given a
+// .gitignore file so that it is not committed by accident.
+func StateDir() (string, error) {
+	dir := viper.GetString("state_dir")
+	if dir == "" {
+		dir = DefaultStateDir
+	}
+
+	dir = ExpandStdValue(dir)
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*\n"), 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
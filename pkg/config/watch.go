@@ -0,0 +1,18 @@
+package config
+
+import "time"
+
+// WatchCommand is a watch run by zxwatch, which runs the command of the watch
+// every time a watched file changes.
+type WatchCommand struct {
+	FileWatch `mapstructure:",squash"`
+
+	// Debounce is how long the watched files must be left alone after a
+	// change before the command is run. It defaults to 250ms.
+	Debounce time.Duration
+
+	// Restart is set for a command that keeps running, like a server. It is
+	// stopped and started again on every change instead of being left to
+	// finish first.
+	Restart bool
+}
@@ -1,7 +1,19 @@
 package config
 
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
 type Web struct {
 	Targets map[string]WebTarget
+
+	// Logs configures the log files the output of each target is kept in.
+	Logs WebLogs
 }
 
 type WebTargetType string
@@ -12,8 +24,9 @@ const (
 	// typical example is a Go API server.
 	ServerTarget WebTargetType = "server"
 
-	// StaticTarget commands are commands that combine the build and file
-	// serving capabilities into a self-sufficient system.
+	// StaticTarget commands are served by a built-in static file server after
+	// running the build commands, if any. A typical example is a bundle of
+	// JavaScript built by a bundler.
 	StaticTarget WebTargetType = "static"
 
 	// DockerTarget commands are commands that run as docker processes and
@@ -25,6 +38,13 @@ const (
 	FrontendTarget WebTargetType = "frontend"
 )
 
+// AutoPort is the port setting that asks zxstart to pick a free port.
+const AutoPort = "auto"
+
+// DefaultPortEnv is the environment variable the port of a server target is
+// passed in unless configured otherwise.
+const DefaultPortEnv = "PORT"
+
 type AddrFmt string
 
 const (
@@ -44,18 +64,303 @@ type WebTarget struct {
 
 	WorkingDir string `mapstructure:"working_dir"`
 
+	// Env is environment to set for the build and run commands. Names are
+	// made upper case. Values are interpolated as for ExpandStdValue, except
+	// that other variables are taken from the env files and the environment
+	// of zxstart.
+	Env map[string]string
+
+	// EnvFile is a list of dotenv files, relative to the working directory,
+	// to read environment from. Later files override earlier files and Env
+	// overrides them all.
+	EnvFile []string `mapstructure:"env_file"`
+
 	AddressMatch  string  `mapstructure:"address_match"`
 	AddressFormat AddrFmt `mapstructure:"address_format"`
 
+	// Port is the port the server listens on. It may be a port number or
+	// "auto" to have zxstart pick a free port. When set, the port is passed to
+	// the build and run commands in the environment, where their arguments
+	// may refer to it as $PORT, and the address_match setting is optional.
+	Port string
+
+	// PortEnv is the name of the environment variable the port is passed in.
+	// It defaults to PORT.
+	PortEnv string `mapstructure:"port_env"`
+
+	Ready ReadyProbe
+
 	OpenBrowser bool `mapstructure:"open_browser"`
 
-	Watches  []FileWatch
+	// StopSignal is the signal sent to stop the commands of the target, e.g.,
+	// SIGINT. It defaults to SIGTERM.
+	StopSignal string `mapstructure:"stop_signal"`
+
+	// StopTimeout is how long to wait for the commands of the target to stop
+	// after sending StopSignal before killing them. It defaults to 10s.
+	StopTimeout time.Duration `mapstructure:"stop_timeout"`
+
+	// NoProcessGroup turns off running the commands of the target in process
+	// groups of their own. Normally, stopping a command stops every process it
+	// started as well.
+	NoProcessGroup bool `mapstructure:"no_process_group"`
+
+	RestartPolicy `mapstructure:",squash"`
+
+	Static StaticServe
+	Docker DockerRun
+
+	Watches []FileWatch
+
+	// Debounce is how long the watched files must be left alone after a
+	// change before the target is rebuilt. All the changes made during that
+	// time are handled by a single rebuild. It defaults to 250ms.
+	Debounce time.Duration
+
 	Dispatch []ProxyDispatch
 
 	Depends []string
 }
 
+// ReadyProbe configures how to tell that a server target is ready to accept
+// requests after it has started. Every probe configured must pass before the
+// server is considered ready. If none are configured, the server is ready as
+// soon as its address is known.
+type ReadyProbe struct {
+	// HTTP is a path to GET from the server.
+	HTTP string
+
+	// Status is the status the HTTP probe expects. It defaults to 200.
+	Status int
+
+	// TCP, when true, probes by connecting to the server address.
+	TCP bool
+
+	// Command is a command that exits with status 0 when the server is
+	// ready. It is run in the working directory of the target with the
+	// environment of the server and ZX_ADDR set to the host:port of the
+	// server.
+	Command []string
+
+	// Interval is how long to wait between attempts. It defaults to 500ms.
+	Interval time.Duration
+
+	// Timeout is how long to keep trying before giving up. It defaults to 30s.
+	Timeout time.Duration
+}
+
+// Enabled returns true if any probe has been configured.
+func (p *ReadyProbe) Enabled() bool {
+	return p.HTTP != "" || p.TCP || len(p.Command) > 0
+}
+
+// StaticServe configures the file server run for static web targets.
+type StaticServe struct {
+	// Root is the directory to serve. It is relative to the working directory
+	// of the target and defaults to the working directory itself.
+	Root string
+
+	// Index is the file served for directory requests. It defaults to
+	// index.html.
+	Index string
+
+	// SPA turns on single page application mode. Requests for paths without
+	// a file extension that do not exist are answered with the root index
+	// file rather than a 404.
+	SPA bool `mapstructure:"spa"`
+
+	// Listing turns on directory listings for directories without an index
+	// file.
+	Listing bool
+
+	// CacheControl is the Cache-Control header to send with every response.
+	// It defaults to no-cache so that browsers always revalidate during
+	// development.
+	CacheControl string `mapstructure:"cache_control"`
+
+	// Listen is the address to listen on. It defaults to ":0", which picks
+	// any free port.
+	Listen string
+}
+
+// DockerRun configures the container run for docker web targets. The Build
+// commands of the target, if any, are used to build the image. Otherwise, the
+// image is built with docker build if a Dockerfile or Context is set. The Run
+// of the target, if any, is passed as the command of the container.
+type DockerRun struct {
+	// Command is the docker CLI to run. It defaults to docker, found on the
+	// PATH.
+	Command string
+
+	// Image is the image to build and run. It defaults to the container name,
+	// in which case the image must be built by the build commands of the
+	// target or with Dockerfile or Context.
+	Image string
+
+	// Name is the name of the container. It defaults to a name made from the
+	// project directory and the target name.
+	Name string
+
+	// Dockerfile is the Dockerfile to build the image with.
+	Dockerfile string
+
+	// Context is the build context directory to build the image with.
+	Context string
+
+	// Ports are the ports to publish, each written as for docker run -p.
+	Ports []string
+
+	// Port is the container port whose published host port is the address of
+	// the target. It defaults to the container port of the first of Ports.
+	Port string
+
+	// Volumes are the volumes to mount, each written as for docker run -v.
+	// Relative host paths are relative to the working directory.
+	Volumes []string
+
+	// Env is the environment to set in the container, each written as
+	// KEY=VALUE. It is set after the env and env_file of the target, so it
+	// overrides them.
+	Env []string
+
+	// Args are any additional arguments to pass to docker run.
+	Args []string
+}
+
 type ProxyDispatch struct {
 	Target string
 	Path   string
 }
+
+// Environ returns the environment configured for the target, each variable
+// written as NAME=VALUE and sorted by name. This only includes variables set by
+// env and env_file, not the environment of the current process.
+func (t *WebTarget) Environ() ([]string, error) {
+	vars := make(map[string]string)
+	for _, file := range t.EnvFile {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(t.WorkingDir, file)
+		}
+
+		fvars, err := ReadEnvFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range fvars {
+			vars[v.Name] = v.Value
+		}
+	}
+
+	lookup := func(n string) string {
+		switch n {
+		case "HOME", "PWD":
+			return BasicEnv(n)
+		}
+
+		if v, ok := vars[n]; ok {
+			return v
+		}
+
+		return os.Getenv(n)
+	}
+
+	expanded := make(map[string]string, len(t.Env))
+	for n, v := range t.Env {
+		expanded[strings.ToUpper(n)] = os.Expand(v, lookup)
+	}
+
+	for n, v := range expanded {
+		vars[n] = v
+	}
+
+	env := make([]string, 0, len(vars))
+	for n, v := range vars {
+		env = append(env, n+"="+v)
+	}
+	sort.Strings(env)
+
+	return env, nil
+}
+
+// DependsOn returns the names of the web targets the target depends upon.
+// They are lowercased to match the target names, which are lowercased when the
+// configuration is read.
+func (t WebTarget) DependsOn() []string {
+	deps := make([]string, len(t.Depends))
+	for i, dep := range t.Depends {
+		deps[i] = strings.ToLower(dep)
+	}
+	return deps
+}
+
+// TargetOrder returns the names of the configured web targets sorted so that
+// every target comes after all the targets it depends upon. Targets with no
+// ordering constraint between them are sorted by name to keep the order
+// stable. It returns an error if a target depends on a target that has not
+// been configured or if the dependencies form a cycle.
+func (w *Web) TargetOrder() ([]string, error) {
+	names := make([]string, 0, len(w.Targets))
+	for name := range w.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	var (
+		order = make([]string, 0, len(names))
+		marks = make(map[string]int, len(names))
+		path  = make([]string, 0, len(names))
+		visit func(string) error
+	)
+
+	visit = func(name string) error {
+		switch marks[name] {
+		case visited:
+			return nil
+		case visiting:
+			cycle := []string{name}
+			for i := len(path) - 1; i >= 0; i-- {
+				cycle = append([]string{path[i]}, cycle...)
+				if path[i] == name {
+					break
+				}
+			}
+			return fmt.Errorf("web targets have a dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		marks[name] = visiting
+		path = append(path, name)
+
+		deps := w.Targets[name].DependsOn()
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if _, ok := w.Targets[dep]; !ok {
+				return fmt.Errorf("web target %q depends on unknown web target %q", name, dep)
+			}
+
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		marks[name] = visited
+		order = append(order, name)
+
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
@@ -0,0 +1,226 @@
+// Package lifecycle describes what happens to each target as zxstart builds
+// and runs it, so that tools can follow along without parsing the output.
+package lifecycle
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Phase is a step in the life of a target.
+type Phase string
+
+const (
+	// Waiting is when the target waits for the targets it depends upon.
+	Waiting Phase = "waiting"
+
+	// BuildStart is when the build of the target starts.
+	BuildStart Phase = "build-start"
+
+	// BuildOK is when the build of the target succeeds.
+	BuildOK Phase = "build-ok"
+
+	// BuildFail is when the build of the target fails.
+	BuildFail Phase = "build-fail"
+
+	// RunStart is when the server of the target is started.
+	RunStart Phase = "run-start"
+
+	// Ready is when the target is listening on its address.
+	Ready Phase = "ready"
+
+	// Exit is when the server of the target quits.
+	Exit Phase = "exit"
+
+	// Restart is when the server of the target is stopped to be started
+	// again.
+	Restart Phase = "restart"
+
+	// Stopped is when the target is stopped until told to start again.
+	Stopped Phase = "stopped"
+
+	// CrashLoop is when the server of the target keeps quitting right after
+	// it starts and it is left stopped rather than being restarted again.
+	CrashLoop Phase = "crash-loop"
+)
+
+// Event is a single step in the life of a target.
+type Event struct {
+	Target string
+	Phase  Phase
+	Time   time.Time
+
+	// Duration is how long the build took for BuildOK and BuildFail.
+	Duration time.Duration
+
+	// Addr is the address of the target for Ready.
+	Addr string
+
+	// ExitCode is the exit code of the build for BuildOK and BuildFail and
+	// of the server for Exit. It is -1 when the process did not exit on its
+	// own, such as when killed by a signal.
+	ExitCode int
+
+	// Files are the changed files that caused a BuildStart or Restart, if
+	// any.
+	Files []string
+
+	// Output is the last lines of output of the server for CrashLoop.
+	Output []string
+
+	// Err is the error the build failed or the server quit with, if any.
+	Err error
+}
+
+// hasExitCode returns true if the phase of the event has an exit code.
+func (e Event) hasExitCode() bool {
+	switch e.Phase {
+	case BuildOK, BuildFail, Exit:
+		return true
+	default:
+		return false
+	}
+}
+
+// jsonEvent is how an Event is written as JSON.
+type jsonEvent struct {
+	Target     string    `json:"target"`
+	Phase      Phase     `json:"phase"`
+	Time       time.Time `json:"time"`
+	DurationMS *int64    `json:"duration_ms,omitempty"`
+	Addr       string    `json:"addr,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	Files      []string  `json:"files,omitempty"`
+	Output     []string  `json:"output,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// MarshalJSON writes the event as a JSON object. The duration is given in
+// milliseconds and the exit code is only given for the phases that have one.
+func (e Event) MarshalJSON() ([]byte, error) {
+	je := jsonEvent{
+		Target: e.Target,
+		Phase:  e.Phase,
+		Time:   e.Time,
+		Addr:   e.Addr,
+		Files:  e.Files,
+		Output: e.Output,
+	}
+
+	if e.Phase == BuildOK || e.Phase == BuildFail {
+		ms := e.Duration.Milliseconds()
+		je.DurationMS = &ms
+	}
+
+	if e.hasExitCode() {
+		code := e.ExitCode
+		je.ExitCode = &code
+	}
+
+	if e.Err != nil {
+		je.Error = e.Err.Error()
+	}
+
+	return json.Marshal(je)
+}
+
+// UnmarshalJSON reads the event from a JSON object written by MarshalJSON.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var je jsonEvent
+	if err := json.Unmarshal(data, &je); err != nil {
+		return err
+	}
+
+	*e = Event{
+		Target: je.Target,
+		Phase:  je.Phase,
+		Time:   je.Time,
+		Addr:   je.Addr,
+		Files:  je.Files,
+		Output: je.Output,
+	}
+
+	if je.DurationMS != nil {
+		e.Duration = time.Duration(*je.DurationMS) * time.Millisecond
+	}
+
+	if je.ExitCode != nil {
+		e.ExitCode = *je.ExitCode
+	}
+
+	if je.Error != "" {
+		e.Err = errors.New(je.Error)
+	}
+
+	return nil
+}
+
+// Feed passes the events published to it to every subscriber.
+type Feed struct {
+	lock sync.Mutex
+	next int
+	subs map[int]func(Event)
+}
+
+// NewFeed returns a feed with no subscribers.
+func NewFeed() *Feed {
+	return &Feed{
+		subs: make(map[int]func(Event)),
+	}
+}
+
+// Subscribe calls the function with every event published from now on, until
+// the returned function is called to unsubscribe. The function is called
+// while publishing, so it must not block.
+func (f *Feed) Subscribe(fn func(Event)) func() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	id := f.next
+	f.next++
+	f.subs[id] = fn
+
+	return func() {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		delete(f.subs, id)
+	}
+}
+
+// Publish sends the event to every subscriber, setting the time of the event
+// to now if it is not set. A nil feed drops the event.
+func (f *Feed) Publish(e Event) {
+	if f == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, fn := range f.subs {
+		fn(e)
+	}
+}
+
+// JSONLines returns a subscriber that writes each event to the writer as a
+// line of JSON. Errors writing are ignored. Publishing waits for each write,
+// so the writer must be quick, like a file or standard output.
+func JSONLines(w io.Writer) func(Event) {
+	var lock sync.Mutex
+	return func(e Event) {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		_, _ = w.Write(append(data, '\n'))
+	}
+}